@@ -4,27 +4,57 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	_ "embed"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"kloud/pkg/device"
+	"kloud/pkg/nextcloud"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 const (
 	configTpl = `server: %s
 share: %s
+`
+	configAccountTpl = `mode: account
+server: %s
+user: %q
+folder: %q
+`
+	configPasswordTpl = `password: %q
+`
+	configSFTPTpl = `type: sftp
+sftp:
+  host: %q
+  user: %q
+  key: %q
+  folder: %q
 `
 	archiveFilename = "KoboRoot.tgz"
 
-	sdMountPoint = "/mnt/onboard"
-	syncDir      = sdMountPoint + "/KloudSync"
-	internalDir  = sdMountPoint + "/.kloud"
+	// NextCloud login flow tokens are valid for 20 minutes
+	loginPollInterval = 5 * time.Second
+	loginTimeout      = 20 * time.Minute
 )
 
+// profile is the device profile kloud is installed for
+var profile = device.Kobo()
+
+// errHostKeyScanned stops the SSH handshake once the host key of the server is known
+var errHostKeyScanned = errors.New("host key scanned")
+
 //go:embed kloud
 var kloudBinary []byte
 
@@ -42,12 +72,52 @@ func help() {
 	fmt.Println("Example: https://cloud.server.com/s/eagB90Oy5uUa4eB.")
 }
 
-func prepareFolderToArchive(wd, serverURL string, shareID string) {
-	// Create config file
+// generateConfig creates the config file for a public share, or for a user account if user is set
+func generateConfig(serverURL, shareID, user, folder, password string) string {
 	config := fmt.Sprintf(configTpl, serverURL, shareID)
+	if user != "" {
+		config = fmt.Sprintf(configAccountTpl, serverURL, user, folder)
+	}
+
+	if password != "" {
+		config += fmt.Sprintf(configPasswordTpl, password)
+	}
+	return config
+}
+
+// generateSFTPConfig creates the config file for a folder of an SSH server
+func generateSFTPConfig(host, user, key, folder string) string {
+	return fmt.Sprintf(configSFTPTpl, host, user, key, folder)
+}
+
+// scanHostKey connects to an SSH server to retrieve its host key and returns it as a known_hosts line
+func scanHostKey(address string) string {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: "kloud",
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyScanned
+		},
+	}
+	_, err := ssh.Dial("tcp", address, config)
+	if hostKey == nil {
+		log.Fatalf("Error retrieving the host key of %s: %v\n", address, err)
+	}
+
+	fmt.Printf("The %s host key of %s is %s, make sure it is the one of your server\n", hostKey.Type(), address, ssh.FingerprintSHA256(hostKey))
+	return knownhosts.Line([]string{knownhosts.Normalize(address)}, hostKey) + "\n"
+}
 
+// prepareFolderToArchive lays out the files to install on the device.
+// files are additional files to write in .kloud, such as SSH keys, readable by their owner only.
+func prepareFolderToArchive(wd, serverURL, config string, files map[string][]byte) {
 	// Create .kloud
-	mntKloudPath := path.Join(wd, internalDir)
+	mntKloudPath := path.Join(wd, profile.InternalDir)
 	if err := os.MkdirAll(mntKloudPath, os.ModePerm); err != nil {
 		log.Fatalf("Error creating .kloud directory: %v\n", err)
 	}
@@ -59,20 +129,30 @@ func prepareFolderToArchive(wd, serverURL string, shareID string) {
 	if err := os.WriteFile(path.Join(mntKloudPath, "config.yml"), []byte(config), 0644); err != nil {
 		log.Fatalf("Error writing Kloud config: %v\n", err)
 	}
+	for name, content := range files {
+		if err := os.WriteFile(path.Join(mntKloudPath, name), content, 0600); err != nil {
+			log.Fatalf("Error writing %s: %v\n", name, err)
+		}
+	}
 
 	// Generate launcher script and copy to .kloud
-	launcherScript := fmt.Sprintf(launcherScriptTpl, serverURL, internalDir, syncDir)
+	launcherScript := fmt.Sprintf(launcherScriptTpl, serverURL, profile.InternalDir, profile.Name)
 	launcherScriptPath := path.Join(mntKloudPath, "launcher.sh")
 	if err := os.WriteFile(launcherScriptPath, []byte(launcherScript), 0644); err != nil {
 		log.Fatalf("Error writing launcher script: %v\n", err)
 	}
 
 	// Create KloudSync (empty)
-	kloudSyncPath := path.Join(wd, syncDir)
+	kloudSyncPath := path.Join(wd, profile.SyncDir)
 	if err := os.MkdirAll(kloudSyncPath, os.ModePerm); err != nil {
 		log.Fatalf("Error creating KloudSync directory: %v\n", err)
 	}
 
+	// Only start syncs when the network comes up on profiles triggered by udev
+	if profile.Trigger != device.TriggerUdev {
+		return
+	}
+
 	// Create udev rules directory
 	udevPath := path.Join(wd, "etc", "udev", "rules.d")
 	if err := os.MkdirAll(udevPath, os.ModePerm); err != nil {
@@ -80,7 +160,7 @@ func prepareFolderToArchive(wd, serverURL string, shareID string) {
 	}
 
 	// Format and copy to udev rules dir
-	onKoboLauncherPath := path.Join(internalDir, "launcher.sh")
+	onKoboLauncherPath := path.Join(profile.InternalDir, "launcher.sh")
 	udevRules := fmt.Sprintf(udevRulesTpl, onKoboLauncherPath, onKoboLauncherPath)
 	if err := os.WriteFile(path.Join(udevPath, "97-kloud.rules"), []byte(udevRules), 0644); err != nil {
 		log.Fatalf("Error writing udev rules: %v\n", err)
@@ -144,9 +224,37 @@ func createArchive(wd string) {
 	filepath.Walk(wd, walkFunc)
 }
 
+// loginFlow runs a NextCloud Login Flow v2 and waits for the user to grant access in their browser
+func loginFlow(serverURL string) nextcloud.Credentials {
+	flow, err := nextcloud.StartLoginFlow(http.DefaultClient, serverURL)
+	if err != nil {
+		log.Fatalf("Error starting NextCloud login: %v\n", err)
+	}
+
+	fmt.Println("Open the following URL in your browser and grant access to kloud:")
+	fmt.Printf("%s\n\n", flow.Login)
+
+	credentials, err := flow.Wait(loginPollInterval, loginTimeout)
+	if err != nil {
+		log.Fatalf("Error waiting for NextCloud login: %v\n", err)
+	}
+
+	fmt.Printf("Logged in as %s\n", credentials.LoginName)
+	return credentials
+}
+
 func main() {
 	serverURL := flag.String("server-url", "", "URL of the NextCloud server")
 	shareID := flag.String("share-id", "", "Share ID of your NextCloud shared directory")
+	login := flag.Bool("login", false, "Log in to your NextCloud account in a browser to generate an app password, instead of passing -user and -password")
+	user := flag.String("user", "", "User name of your NextCloud account, to sync a folder of the account instead of a share")
+	folder := flag.String("folder", "", "Folder of your NextCloud account to sync, when -user is set")
+	password := flag.String("password", "", "Password of your NextCloud shared directory if it is protected, or app password of your account")
+	sftpHost := flag.String("sftp-host", "", "Host (and port) of an SSH server to sync from over SFTP, instead of NextCloud")
+	sftpUser := flag.String("sftp-user", "", "User name on the SSH server, when -sftp-host is set")
+	sftpKey := flag.String("sftp-key", "", "Path of the private key kloud authenticates with, when -sftp-host is set. It must not have a passphrase")
+	sftpFolder := flag.String("sftp-folder", "", "Folder of the SSH server to sync, when -sftp-host is set")
+	profileName := flag.String("profile", device.NameKobo, "Where to install kloud: kobo for the internal storage, kobo-sd for the external SD card")
 	flag.Usage = func() {
 		fmt.Printf("Kloud bootstraper\n\n")
 
@@ -159,7 +267,29 @@ func main() {
 
 	flag.Parse()
 
-	if *serverURL == "" || *shareID == "" {
+	if *profileName != device.NameKobo && *profileName != device.NameKoboSD {
+		flag.Usage()
+		os.Exit(1)
+	}
+	profile, _ = device.Lookup(*profileName)
+
+	if *sftpHost != "" {
+		bootstrapSFTP(*sftpHost, *sftpUser, *sftpKey, *sftpFolder)
+		return
+	}
+
+	if *serverURL == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *login {
+		credentials := loginFlow(*serverURL)
+		// The app password logs in with the login name, kloud asks the server for the user ID naming the files
+		*serverURL, *user, *password = credentials.Server, credentials.LoginName, credentials.AppPassword
+	}
+
+	if (*shareID == "" && *user == "") || (*user != "" && *password == "") {
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -171,7 +301,47 @@ func main() {
 	}
 	defer os.RemoveAll(wd)
 
-	prepareFolderToArchive(wd, *serverURL, *shareID)
+	config := generateConfig(*serverURL, *shareID, *user, *folder, *password)
+	prepareFolderToArchive(wd, *serverURL, config, nil)
+	createArchive(wd)
+
+	fmt.Printf("A %s file was created, copy it to your .kobo folder to apply the update\n", archiveFilename)
+}
+
+// bootstrapSFTP creates the archive for a folder of an SSH server, trusting its current host key
+func bootstrapSFTP(host, user, keyPath, folder string) {
+	if user == "" || keyPath == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		log.Fatalf("Error reading SSH key: %v\n", err)
+	}
+	if _, err := ssh.ParsePrivateKey(key); err != nil {
+		log.Fatalf("Error parsing SSH key, it must not have a passphrase: %v\n", err)
+	}
+
+	wd, err := os.MkdirTemp("", "kloud-bootstraper")
+	if err != nil {
+		log.Fatalf("Unable to create working directory: %s", err)
+	}
+	defer os.RemoveAll(wd)
+
+	keyName := filepath.Base(keyPath)
+	files := map[string][]byte{
+		keyName:       key,
+		"known_hosts": []byte(scanHostKey(host)),
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	config := generateSFTPConfig(host, user, keyName, folder)
+	prepareFolderToArchive(wd, hostname, config, files)
 	createArchive(wd)
 
 	fmt.Printf("A %s file was created, copy it to your .kobo folder to apply the update\n", archiveFilename)
@@ -4,9 +4,6 @@
 
 export DBUS_SESSION_BUS_ADDRESS=unix:path=//var/run/dbus/system_bus_socket 
 
-kloud_library_folder="/mnt/onboard/KloudSync"
-sd_library_folder="/mnt/sd/KloudSync"
-
 kloud_internal_folder="/mnt/onboard/.kloud"
 log_file="$kloud_internal_folder/launcher.log"
 
@@ -24,22 +21,11 @@ dbus-monitor "$watch_exp" | while read -r line; do
     # Make sure we have internet access
     ping -c2 1.1.1.1 > /dev/null
 
-    # Run kloud
+    # Run kloud, which refreshes the library once synced
     log "Starting kloud"
-    "$kloud_internal_folder/kloud"
+    "$kloud_internal_folder/kloud" --profile kobo
     exit_code=$?
 
     log "kloud exited with code $exit_code"
-    if [ $exit_code != 0 ]; then
-      log "kloud exited with an error, not performing library refresh"
-    else
-      # Trick to refresh library: https://github.com/wernerb/kobo-wget-sync/blob/master/src/usr/local/wget-sync/refresh_library.sh
-      mkdir -p "$sd_library_folder"
-      mount "$kloud_library_folder" "$sd_library_folder"
-
-      log "Refreshing library"
-      echo sd add /dev/mmcblk1p1 >> /tmp/nickel-hardware-status
-      log "Done"
-    fi
   fi
 done
@@ -2,8 +2,7 @@
 
 cloud_url="%s"
 internal_dir="%s"
-sync_dir="%s"
-sd_sync_dir="/mnt/sd/KloudSync"
+profile="%s"
 
 log_file="$internal_dir/launcher.log"
 
@@ -11,7 +10,7 @@ function log() {
   echo "[$(date)] $1" >> "$log_file"
 }
 
-log "Launcher started. internal_dir=$internal_dir, sync_dir=$sync_dir, sd_sync_dir=$sd_sync_dir"
+log "Launcher started. internal_dir=$internal_dir, profile=$profile"
 
 # Wait for DNS resolution to come in
 for i in $(seq 0 60); do
@@ -30,25 +29,14 @@ if [ $i -eq 60 ]; then
   exit 1
 fi
 
-# Run kloud
+# Run kloud, which refreshes the library once synced
 log "Starting kloud"
-"$internal_dir/kloud"
+"$internal_dir/kloud" --profile "$profile" --internal-dir "$internal_dir"
 exit_code=$?
 log "kloud exited with code $exit_code"
 
 if [ $exit_code != 0 ]; then
-  log "kloud exited with an error, not performing library refresh"
   exit 1
 fi
 
-# Trick to refresh library: https://github.com/wernerb/kobo-wget-sync/blob/master/src/usr/local/wget-sync/refresh_library.sh
-mkdir -p "$sd_sync_dir"
-mount "$sync_dir" "$sd_sync_dir"
-if [ $? -ne 0 ]; then
-  log "Failed to perform library refresh (mount)"
-  exit 1
-fi
-
-log "Refreshing library"
-echo sd add /dev/mmcblk1p1 >> /tmp/nickel-hardware-status
 log "Done"
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"kloud/pkg/backend"
+	"kloud/pkg/config"
+	"kloud/pkg/nickel"
+	"kloud/pkg/state"
+
+	"github.com/sirupsen/logrus"
+)
+
+// excludeAnnotations removes the annotations exported by kloud from a remote listing, they are not part of the library
+func excludeAnnotations(remoteFiles map[string]backend.Entry, conf config.Annotations) {
+	folder := strings.Trim(conf.Folder, "/")
+	if folder == "" {
+		return
+	}
+
+	for fileName := range remoteFiles {
+		if strings.HasPrefix(fileName, folder+"/") {
+			delete(remoteFiles, fileName)
+		}
+	}
+}
+
+// exportAnnotations renders the highlights, notes and bookmarks of the synced books, one file per book, and uploads
+// the files that changed since the last export. Exports of books without annotations left are deleted.
+// It reports whether the remote library was modified.
+func exportAnnotations(db *nickel.DB, conf config.Annotations, remote backend.Backend, syncState *state.State) bool {
+	folder := strings.Trim(conf.Folder, "/")
+	if folder == "" || db == nil {
+		return false
+	}
+
+	uploader, ok := remote.(backend.Uploader)
+	if ok == false {
+		logger.Warn("The backend cannot upload files, annotations are not exported")
+		return false
+	}
+
+	exports, err := renderAnnotations(db, conf, folder)
+	if err != nil {
+		logger.WithField("error", err).Warn("Cannot read the annotations, they will be exported on the next sync")
+		return false
+	}
+
+	if syncState.Annotations == nil {
+		syncState.Annotations = map[string]string{}
+	}
+
+	modified := false
+
+	for fileName, content := range exports {
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:])
+		if syncState.Annotations[fileName] == hash {
+			continue
+		}
+
+		if err := uploader.Upload(fileName, bytes.NewReader(content)); err != nil {
+			logger.WithFields(logrus.Fields{"file": fileName, "error": err}).Error("Cannot upload annotations")
+			continue
+		}
+		logger.WithField("file", fileName).Info("Exported annotations")
+		syncState.Annotations[fileName] = hash
+		modified = true
+	}
+
+	for fileName := range syncState.Annotations {
+		if _, ok := exports[fileName]; ok {
+			continue
+		}
+
+		if deleter, ok := remote.(backend.Deleter); ok {
+			if err := deleter.Delete(fileName); err != nil {
+				logger.WithFields(logrus.Fields{"file": fileName, "error": err}).Error("Cannot delete annotations")
+				continue
+			}
+			modified = true
+		}
+		delete(syncState.Annotations, fileName)
+	}
+	return modified
+}
+
+// renderAnnotations reads the annotations of the synced books from the database of Nickel and renders them,
+// by the remote path of their export
+func renderAnnotations(db *nickel.DB, conf config.Annotations, folder string) (map[string][]byte, error) {
+	localFiles, err := getLocalFiles(profile.SyncDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the synced books from the paths Nickel knows them by
+	books := map[string]string{}
+	for fileName := range localFiles {
+		for _, nickelPath := range profile.NickelPaths(filepath.Join(profile.SyncDir, filepath.FromSlash(fileName))) {
+			books[nickelPath] = fileName
+		}
+	}
+
+	annotations, err := db.Annotations(profile.NickelPaths(profile.SyncDir))
+	if err != nil {
+		return nil, err
+	}
+
+	exports := map[string][]byte{}
+	for nickelPath, book := range annotations {
+		fileName, ok := books[nickelPath]
+		if ok == false || len(book.Annotations) == 0 {
+			continue
+		}
+
+		// Name the export after the book, without its extension
+		name := strings.TrimSuffix(fileName, path.Ext(fileName))
+		name = strings.TrimSuffix(name, ".kepub")
+		if book.Title == "" {
+			book.Title = path.Base(name)
+		}
+
+		if conf.Format == config.FormatJSON {
+			content, err := json.MarshalIndent(book, "", "  ")
+			if err != nil {
+				return nil, err
+			}
+			exports[path.Join(folder, name+".json")] = append(content, '\n')
+		} else {
+			exports[path.Join(folder, name+".md")] = renderMarkdown(book)
+		}
+	}
+
+	return exports, nil
+}
+
+// renderMarkdown renders the annotations of a book as Markdown, quoting the highlights under their chapter
+func renderMarkdown(book nickel.BookAnnotations) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", book.Title)
+	if book.Author != "" {
+		fmt.Fprintf(&b, "*%s*\n\n", book.Author)
+	}
+
+	chapter := ""
+	for _, a := range book.Annotations {
+		if a.Chapter != "" && a.Chapter != chapter {
+			chapter = a.Chapter
+			fmt.Fprintf(&b, "## %s\n\n", chapter)
+		}
+
+		if a.Type == nickel.AnnotationBookmark {
+			fmt.Fprintf(&b, "- Bookmark, %.0f%% into the chapter\n\n", a.Progress*100)
+			continue
+		}
+		if text := strings.TrimSpace(a.Text); text != "" {
+			for _, line := range strings.Split(text, "\n") {
+				fmt.Fprintf(&b, "> %s\n", strings.TrimSpace(line))
+			}
+			b.WriteString("\n")
+		}
+		if note := strings.TrimSpace(a.Note); note != "" {
+			fmt.Fprintf(&b, "%s\n\n", note)
+		}
+	}
+
+	return []byte(b.String())
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"kloud/pkg/autoindex"
+	"kloud/pkg/backend"
+	"kloud/pkg/calibre"
+	"kloud/pkg/config"
+	"kloud/pkg/nextcloud"
+	"kloud/pkg/opds"
+	"kloud/pkg/s3"
+	"kloud/pkg/sftp"
+	"kloud/pkg/webdav"
+)
+
+// newBackend creates the backend selected by the type of the configuration
+func newBackend(conf config.Config) (backend.Backend, error) {
+	switch conf.Type {
+	case "", config.TypeNextCloud:
+		return newNextCloudBackend(conf)
+	case config.TypeWebDAV:
+		return newWebDAVBackend(conf)
+	case config.TypeOPDS:
+		return newOPDSBackend(conf)
+	case config.TypeCalibre:
+		return newCalibreBackend(conf)
+	case config.TypeS3:
+		return newS3Backend(conf)
+	case config.TypeSFTP:
+		return newSFTPBackend(conf)
+	case config.TypeAutoindex:
+		return newAutoindexBackend(conf)
+	default:
+		return nil, config.ErrUnknownType
+	}
+}
+
+func newNextCloudBackend(conf config.Config) (backend.Backend, error) {
+	var (
+		client nextcloud.Client
+		err    error
+	)
+
+	traversal := nextcloud.Traversal(conf.Traversal)
+	if conf.Mode == config.ModeAccount {
+		client, err = nextcloud.NewAccountClient(cacert, conf.Server, conf.User, conf.Password, conf.Folder, traversal)
+	} else {
+		client, err = nextcloud.NewClient(cacert, conf.Server, conf.ShareID, conf.Password, traversal)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return nextcloud.Library{Client: &client}, nil
+}
+
+func newWebDAVBackend(conf config.Config) (backend.Backend, error) {
+	var auth webdav.Auth
+	switch conf.WebDAV.Auth {
+	case config.AuthBasic:
+		auth = webdav.BasicAuth(conf.WebDAV.User, conf.WebDAV.Password)
+	case config.AuthBearer:
+		auth = webdav.BearerAuth(conf.WebDAV.Token)
+	}
+
+	client, err := webdav.NewClient(cacert, conf.WebDAV.URL, auth, webdav.Traversal(conf.Traversal))
+	if err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+func newOPDSBackend(conf config.Config) (backend.Backend, error) {
+	client, err := opds.NewClient(cacert, conf.OPDS.URL, conf.OPDS.User, conf.OPDS.Password, opds.Folders(conf.OPDS.Folders))
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func newCalibreBackend(conf config.Config) (backend.Backend, error) {
+	c := conf.Calibre
+	client, err := calibre.NewClient(cacert, c.URL, c.Library, c.User, c.Password, c.SavedSearch, c.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func newS3Backend(conf config.Config) (backend.Backend, error) {
+	c := conf.S3
+	client, err := s3.NewClient(cacert, c.Endpoint, c.Region, c.Bucket, c.Prefix, c.AccessKey, c.SecretKey, c.VirtualHost)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// defaultSFTPKey is the private key file used when the configuration does not name one
+const defaultSFTPKey = "id_ed25519"
+
+func newSFTPBackend(conf config.Config) (backend.Backend, error) {
+	c := conf.SFTP
+	if c.Key == "" {
+		c.Key = defaultSFTPKey
+	}
+
+	key, err := ioutil.ReadFile(filepath.Join(profile.InternalDir, c.Key))
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(c.Host, c.User, key, filepath.Join(profile.InternalDir, "known_hosts"), c.Folder)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func newAutoindexBackend(conf config.Config) (backend.Backend, error) {
+	client, err := autoindex.NewClient(cacert, conf.Autoindex.URL, conf.Autoindex.User, conf.Autoindex.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
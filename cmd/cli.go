@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"kloud/pkg/config"
+	"kloud/pkg/device"
+	"kloud/pkg/state"
+	"kloud/pkg/webdav"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// kloudVersion is the version of kloud, set at build time with -ldflags "-X main.kloudVersion=..."
+var kloudVersion = "dev"
+
+// Settings shared by the subcommands, set by the global flags and the configuration
+var (
+	profile    device.Profile
+	configPath string
+)
+
+// command is a subcommand of the kloud binary
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+	// logs is whether the command writes to the log file, the others leave the internal directory untouched
+	logs bool
+}
+
+// commands returns the subcommands of the kloud binary, the first one being the default
+func commands() []command {
+	return []command{
+		{"sync", "Bring the local library up to date with the remote one", runSync, true},
+		{"plan", "Print what a sync would download and delete, without doing it", runPlan, true},
+		{"status", "Print the configuration and the state of the last sync", runStatus, true},
+		{"verify", "Check the local library against the remote one", runVerify, true},
+		{"reset-state", "Forget what previous syncs remembered, forcing a full sync", runResetState, true},
+		{"config", "Print the configuration with its secrets redacted (config show)", runConfig, false},
+		{"version", "Print the version of kloud", runVersion, false},
+		{"log", "Print the last lines of the log (log tail)", runLog, false},
+	}
+}
+
+// configFile returns the path of the configuration file
+func configFile() string {
+	if configPath != "" {
+		return configPath
+	}
+	return filepath.Join(profile.InternalDir, "config.yml")
+}
+
+// statePath returns the path of the file keeping the state of the syncs
+func statePath() string {
+	return filepath.Join(profile.InternalDir, "state.json")
+}
+
+// logPath returns the path of the log file
+func logPath() string {
+	return filepath.Join(profile.InternalDir, "kloud.log")
+}
+
+// run parses the global flags and runs the requested subcommand, returning the exit code
+func run(args []string) int {
+	flags := flag.NewFlagSet("kloud", flag.ContinueOnError)
+	profileName := flags.String("profile", "", "Device profile: kobo, kobo-sd or desktop (default detected)")
+	internalDir := flags.String("internal-dir", "", "Directory of the configuration, state and log of kloud (default from the profile)")
+	flags.StringVar(&configPath, "config", "", "Path of the configuration file (default <internal-dir>/config.yml)")
+	logLevel := flags.String("log-level", "info", "Minimum level of the logs: debug, info, warn or error")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: kloud [flags] [command] [command flags]\n\nCommands:\n")
+		for _, c := range commands() {
+			fmt.Fprintf(flags.Output(), "  %-12s %s\n", c.name, c.usage)
+		}
+		fmt.Fprintf(flags.Output(), "\nFlags:\n")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	level, err := logrus.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	logger.SetLevel(level)
+	webdav.Logger = logger
+
+	if err := resolveProfile(*profileName, *internalDir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	// Sync when no command is given, as the launcher does
+	name, commandArgs := commands()[0].name, flags.Args()
+	if len(commandArgs) > 0 {
+		name, commandArgs = commandArgs[0], commandArgs[1:]
+	}
+
+	for _, c := range commands() {
+		if c.name == name {
+			if c.logs {
+				openLog()
+			}
+			return c.run(commandArgs)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	flags.Usage()
+	return 2
+}
+
+// resolveProfile picks the device profile from the flags, the configuration or the device itself.
+// The internal directory stays where the configuration was found.
+func resolveProfile(name, internalDir string) error {
+	profile = device.Detect()
+	if name != "" {
+		p, err := device.Lookup(name)
+		if err != nil {
+			return err
+		}
+		profile = p
+	}
+	if internalDir == "" {
+		internalDir = profile.InternalDir
+	}
+	profile.InternalDir = internalDir
+
+	// Commands reading the configuration report its errors
+	conf, err := config.Load(configFile())
+	if err != nil {
+		return nil
+	}
+
+	if name == "" && conf.Device.Profile != "" {
+		profile, _ = device.Lookup(conf.Device.Profile)
+		profile.InternalDir = internalDir
+	}
+	if conf.Device.SyncDir != "" {
+		profile.SyncDir = conf.Device.SyncDir
+	}
+	if conf.Device.Refresh != "" {
+		profile.Refresh = conf.Device.Refresh
+	}
+
+	return nil
+}
+
+// deviceFlag adds the flag of the commands able to work on a Kobo mounted over USB
+func deviceFlag(flags *flag.FlagSet) *string {
+	return flags.String("device", "", "Mount point of a Kobo plugged over USB to work on, or auto to find it")
+}
+
+// useDevice switches to the Kobo mounted at mountPoint, sharing the library and the state of kloud on the device.
+// The current profile is kept when mountPoint is empty.
+func useDevice(mountPoint string) error {
+	if mountPoint == "" {
+		return nil
+	}
+
+	if mountPoint == "auto" {
+		var err error
+		mountPoint, err = device.FindKobo()
+		if err != nil {
+			return err
+		}
+	}
+
+	p, err := device.KoboUSB(mountPoint)
+	if err != nil {
+		return fmt.Errorf("%s: %w", mountPoint, err)
+	}
+	profile = p
+
+	// The configuration of the device may have moved the library on its internal storage
+	if conf, err := config.Load(configFile()); err == nil && conf.Device.SyncDir != "" {
+		profile.SyncDir, err = device.Rebase(conf.Device.SyncDir, mountPoint)
+		if err != nil {
+			return fmt.Errorf("%s: %w", conf.Device.SyncDir, err)
+		}
+	}
+
+	version, _ := device.Version(mountPoint)
+	logger.WithFields(logrus.Fields{"mount_point": mountPoint, "version": version}).Info("Using a Kobo mounted over USB")
+	return nil
+}
+
+// runPlan prints what a sync would do
+func runPlan(args []string) int {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	jsonReport := flags.Bool("json", false, "Print the plan as JSON")
+	mountPoint := deviceFlag(flags)
+	flags.Parse(args)
+
+	if err := useDevice(*mountPoint); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	plan, err := planSync(false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot plan sync: %v\n", err)
+		return 1
+	}
+	defer closeBackend(plan.remote)
+
+	report := struct {
+		Download []string `json:"download"`
+		Delete   []string `json:"delete"`
+	}{Download: []string{}, Delete: plan.toDelete}
+	for _, file := range plan.toDownload {
+		report.Download = append(report.Download, file.Path)
+	}
+	if report.Delete == nil {
+		report.Delete = []string{}
+	}
+
+	if *jsonReport {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+		return 0
+	}
+
+	for _, fileName := range report.Download {
+		fmt.Printf("download %s\n", fileName)
+	}
+	for _, fileName := range report.Delete {
+		fmt.Printf("delete   %s\n", fileName)
+	}
+	fmt.Printf("%d files to download, %d files to delete\n", len(report.Download), len(report.Delete))
+	return 0
+}
+
+// runStatus prints the configuration and what the state remembers of the last syncs
+func runStatus(args []string) int {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	mountPoint := deviceFlag(flags)
+	flags.Parse(args)
+
+	if err := useDevice(*mountPoint); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	conf, err := config.Load(configFile())
+	if err != nil {
+		fmt.Printf("Configuration: invalid (%v)\n", err)
+	} else {
+		backendType := conf.Type
+		if backendType == "" {
+			backendType = config.TypeNextCloud
+		}
+		fmt.Printf("Configuration: %s backend\n", backendType)
+	}
+
+	syncState, err := state.Load(statePath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read sync state: %v\n", err)
+		return 1
+	}
+
+	localFiles, err := getLocalFiles(profile.SyncDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read local filesystem: %v\n", err)
+		return 1
+	}
+
+	lastSuccess := "never"
+	if syncState.LastSuccess.IsZero() == false {
+		lastSuccess = syncState.LastSuccess.Format("2006-01-02 15:04:05 MST")
+	}
+
+	fmt.Printf("Device profile: %s (library refresh: %s, trigger: %s)\n", profile.Name, profile.Refresh, profile.Trigger)
+	fmt.Printf("Internal directory: %s\n", profile.InternalDir)
+	fmt.Printf("Sync directory: %s (%d files)\n", profile.SyncDir, len(localFiles))
+	fmt.Printf("Last successful sync: %s\n", lastSuccess)
+	fmt.Printf("Remote version: %s\n", valueOr(syncState.Version, "unknown"))
+	fmt.Printf("Incremental listing: %t (%d files)\n", syncState.SyncToken != "", len(syncState.Remote))
+	fmt.Printf("Files pending download: %d\n", len(syncState.Redownload))
+	return 0
+}
+
+// runResetState deletes the state, so the next sync lists and compares the whole library
+func runResetState(args []string) int {
+	flags := flag.NewFlagSet("reset-state", flag.ExitOnError)
+	mountPoint := deviceFlag(flags)
+	flags.Parse(args)
+
+	if err := useDevice(*mountPoint); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if err := os.Remove(statePath()); err != nil && os.IsNotExist(err) == false {
+		fmt.Fprintf(os.Stderr, "Cannot reset state: %v\n", err)
+		return 1
+	}
+
+	logger.Info("State reset")
+	fmt.Println("State reset, the next sync will compare the whole library")
+	return 0
+}
+
+// runConfig prints the configuration with its secrets redacted
+func runConfig(args []string) int {
+	if len(args) != 1 || args[0] != "show" {
+		fmt.Fprintln(os.Stderr, "Usage: kloud config show")
+		return 2
+	}
+
+	conf, err := config.Load(configFile())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot retrieve configuration: %v\n", err)
+		return 1
+	}
+
+	out, err := yaml.Marshal(conf.Redacted())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Print(string(out))
+	return 0
+}
+
+// runVersion prints the version of kloud
+func runVersion(args []string) int {
+	fmt.Println(kloudVersion)
+	return 0
+}
+
+// runLog prints the last lines of the log file
+func runLog(args []string) int {
+	if len(args) == 0 || args[0] != "tail" {
+		fmt.Fprintln(os.Stderr, "Usage: kloud log tail [-n lines]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("log tail", flag.ExitOnError)
+	count := flags.Int("n", 20, "Number of lines to print")
+	flags.Parse(args[1:])
+	if *count < 1 {
+		fmt.Fprintln(os.Stderr, "The number of lines must be at least 1")
+		flags.Usage()
+		return 2
+	}
+
+	file, err := os.Open(logPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open log file: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	// Keep the last lines in a ring as the log is read
+	lines := make([]string, 0, *count)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		if len(lines) == *count {
+			lines = lines[1:]
+		}
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read log file: %v\n", err)
+		return 1
+	}
+
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	return 0
+}
+
+// valueOr returns value, or fallback when it is empty
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -2,29 +2,53 @@ package main
 
 import (
 	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"kloud/pkg/backend"
+	"kloud/pkg/checksum"
 	"kloud/pkg/config"
-	"kloud/pkg/consts"
-	"kloud/pkg/nextcloud"
+	"kloud/pkg/state"
 
 	"github.com/sirupsen/logrus"
 )
 
 var logger = logrus.New()
 
+const (
+	// maxResumes is the number of times an interrupted download is resumed before giving up
+	maxResumes = 3
+	// maxAttempts is the number of times a download failing verification is attempted
+	maxAttempts = 3
+	// partSuffix is appended to the name of files being downloaded until they are verified
+	partSuffix = ".kloud-part"
+)
+
+// Errors returned while downloading files
+var (
+	ErrSizeMismatch = errors.New("downloaded size does not match the remote file")
+)
+
 //go:embed cacert.pem
 var cacert []byte
 
-func getLocalFiles(root string) (map[string]int64, error) {
-	ret := map[string]int64{}
+func getLocalFiles(root string) (map[string]backend.Entry, error) {
+	ret := map[string]backend.Entry{}
 
-	// Walk the local filesystems and return a map[filename]filesize
+	// Walk the local filesystems and return a map[filename]file
 	err := filepath.Walk(root, func(path string, fileinfo fs.FileInfo, err error) error {
+		// The sync directory is created by the first download
+		if path == root && os.IsNotExist(err) {
+			return filepath.SkipDir
+		}
 		if err != nil {
 			return err
 		}
@@ -33,8 +57,49 @@ func getLocalFiles(root string) (map[string]int64, error) {
 			return nil
 		}
 
-		relativePath := strings.ReplaceAll(path, consts.SyncDir+"/", "")
-		ret[relativePath] = fileinfo.Size()
+		relativePath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		ret[relativePath] = backend.Entry{Path: relativePath, Size: fileinfo.Size(), ModTime: fileinfo.ModTime()}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// modTimeTolerance is how much earlier than the remote file a local copy can be dated. The FAT filesystem of the
+// Kobo stores modification times at a 2 second granularity, rounded down.
+const modTimeTolerance = 2 * time.Second
+
+// isNewer reports whether the remote file was modified after the local copy was written
+func isNewer(remote, local backend.Entry) bool {
+	if remote.ModTime.IsZero() {
+		return false
+	}
+	return remote.ModTime.Sub(local.ModTime) >= modTimeTolerance
+}
+
+// getRemoteFiles lists the remote library. Backends able to list changes are only asked for what changed
+// since the previous sync, applied to the listing kept in the state.
+func getRemoteFiles(remote backend.Backend, syncState *state.State) (map[string]backend.Entry, error) {
+	if lister, ok := remote.(backend.ChangeLister); ok {
+		ret, err := listChanges(lister, syncState)
+		if errors.Is(err, backend.ErrNotSupported) == false {
+			return ret, err
+		}
+		logger.WithField("error", err).Info("The server cannot list changes, listing the whole library")
+	}
+	syncState.SyncToken, syncState.Remote = "", nil
+
+	// List the remote library and return a map[filename]file
+	ret := map[string]backend.Entry{}
+	err := remote.List(func(entry backend.Entry) error {
+		ret[entry.Path] = entry
 		return nil
 	})
 
@@ -44,12 +109,62 @@ func getLocalFiles(root string) (map[string]int64, error) {
 	return ret, nil
 }
 
-func diffFiles(local, remote map[string]int64) (toDownload, toDelete []string) {
+// listChanges updates the remote listing of the state with the changes since its sync token.
+// The whole library is listed again when there is no listing yet or when the server rejects the token.
+func listChanges(lister backend.ChangeLister, syncState *state.State) (map[string]backend.Entry, error) {
+	ret, token := syncState.Remote, syncState.SyncToken
+	if ret == nil || token == "" {
+		ret, token = map[string]backend.Entry{}, ""
+	}
+
+	apply := func(change backend.Change) error {
+		if change.Deleted == false {
+			ret[change.Path] = change.Entry
+			return nil
+		}
+
+		// A deleted folder takes its files with it
+		for fileName := range ret {
+			if fileName == change.Path || strings.HasPrefix(fileName, change.Path+"/") {
+				delete(ret, fileName)
+			}
+		}
+		return nil
+	}
+
+	newToken, err := lister.ListChanges(token, apply)
+	if errors.Is(err, backend.ErrInvalidToken) {
+		logger.Info("The server rejected the sync token, listing the whole library")
+		ret = map[string]backend.Entry{}
+		newToken, err = lister.ListChanges("", apply)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	syncState.SyncToken, syncState.Remote = newToken, ret
+	return ret, nil
+}
+
+// etagChanged reports whether the remote file has a different version than the one downloaded by the last sync
+func etagChanged(remote backend.Entry, etags map[string]string) bool {
+	etag, known := etags[remote.Path]
+	return remote.ETag != "" && known && etag != remote.ETag
+}
+
+func diffFiles(local, remote map[string]backend.Entry, syncState state.State) (toDownload []backend.Entry, toDelete []string) {
+	// Files found corrupted are downloaded again whatever their metadata
+	redownload := map[string]bool{}
+	for _, fileName := range syncState.Redownload {
+		redownload[fileName] = true
+	}
+
 	// Find what files should be downloaded from the remote server
-	for remoteFileName, remoteFileSize := range remote {
-		localFileSize, localFileExists := local[remoteFileName]
-		if localFileExists == false || localFileSize != remoteFileSize {
-			toDownload = append(toDownload, remoteFileName)
+	for remoteFileName, remoteFile := range remote {
+		localFile, localFileExists := local[remoteFileName]
+		sizeDiffers := remoteFile.Size >= 0 && localFile.Size != remoteFile.Size
+		if localFileExists == false || sizeDiffers || isNewer(remoteFile, localFile) || etagChanged(remoteFile, syncState.ETags) || redownload[remoteFileName] {
+			toDownload = append(toDownload, remoteFile)
 		}
 	}
 
@@ -64,29 +179,146 @@ func diffFiles(local, remote map[string]int64) (toDownload, toDelete []string) {
 	return toDownload, toDelete
 }
 
-func downloadFiles(client nextcloud.Client, files []string) error {
-	// Iterate over the files and download each one into the sync directory
-	for _, fileName := range files {
-		// Download file
-		fileContent, err := client.DownloadFile(fileName)
-		if err != nil {
-			return err
+// syncedETags returns the versions of the remote files that are already up to date locally
+func syncedETags(remote map[string]backend.Entry, toDownload []backend.Entry) map[string]string {
+	etags := map[string]string{}
+	for fileName, file := range remote {
+		if file.ETag != "" {
+			etags[fileName] = file.ETag
 		}
+	}
+	for _, file := range toDownload {
+		delete(etags, file.Path)
+	}
+	return etags
+}
+
+// downloadFiles downloads the files into the sync directory. Files failing verification after every attempt
+// are rejected and returned, leaving the previous local copy untouched, while other errors stop the downloads.
+func downloadFiles(remote backend.Backend, files []backend.Entry, etags map[string]string) ([]string, error) {
+	var rejected []string
 
+	// Iterate over the files and download each one into the sync directory
+	for _, file := range files {
 		// Create directory if needed
-		fullPath := filepath.Join(consts.SyncDir, fileName)
+		fullPath := filepath.Join(profile.SyncDir, filepath.FromSlash(file.Path))
 		dir := filepath.Dir(fullPath)
 
 		if err := os.MkdirAll(dir, 0700); err != nil {
-			return err
+			return rejected, err
 		}
 
-		// Write file
-		if err := ioutil.WriteFile(fullPath, fileContent, 0600); err != nil {
-			return err
+		// Download, verify and write file
+		err := downloadVerifiedFile(remote, file, fullPath)
+		if errors.Is(err, checksum.ErrMismatch) || errors.Is(err, ErrSizeMismatch) {
+			logger.WithFields(logrus.Fields{"file": file.Path, "error": err}).Error("Rejected corrupted download")
+			rejected = append(rejected, file.Path)
+			continue
+		}
+		if err != nil {
+			return rejected, err
+		}
+
+		// Keep the remote modification time so Nickel sorts books by when they were added
+		if file.ModTime.IsZero() == false {
+			if err := os.Chtimes(fullPath, file.ModTime, file.ModTime); err != nil {
+				return rejected, err
+			}
+		}
+
+		// Remember the downloaded version to notice when it changes
+		if file.ETag != "" {
+			etags[file.Path] = file.ETag
+		}
+	}
+
+	return rejected, nil
+}
+
+// downloadVerifiedFile downloads a file next to its destination and only moves it in place once verified.
+// Downloads failing verification are attempted again from scratch.
+func downloadVerifiedFile(remote backend.Backend, remoteFile backend.Entry, fullPath string) error {
+	partPath := fullPath + partSuffix
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = downloadFile(remote, remoteFile, partPath)
+		if errors.Is(err, checksum.ErrMismatch) == false && errors.Is(err, ErrSizeMismatch) == false {
+			break
+		}
+		logger.WithFields(logrus.Fields{"file": remoteFile.Path, "attempt": attempt, "error": err}).Warn("Download failed verification")
+	}
+
+	if err != nil {
+		os.Remove(partPath)
+		return err
+	}
+	return os.Rename(partPath, fullPath)
+}
+
+// downloadFile streams a remote file to the local filesystem and verifies its size and checksum when known.
+// Interrupted transfers are resumed where they stopped when the backend supports it.
+func downloadFile(remote backend.Backend, remoteFile backend.Entry, fullPath string) error {
+	body, err := remote.Open(remoteFile.Path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		body.Close()
+		return err
+	}
+
+	// Hash the file while it is written
+	var w io.Writer = file
+	var verifier *checksum.Verifier
+	if remoteFile.Checksum != "" {
+		verifier, err = checksum.NewVerifier(remoteFile.Checksum)
+		if err != nil {
+			logger.WithFields(logrus.Fields{"file": remoteFile.Path, "error": err}).Warn("Cannot verify checksum")
+		} else {
+			w = io.MultiWriter(file, verifier)
+		}
+	}
+
+	written, err := io.Copy(w, body)
+	body.Close()
+
+	ranger, canResume := remote.(backend.RangeOpener)
+	for resumes := 0; err != nil && canResume && written > 0 && resumes < maxResumes; resumes++ {
+		logger.WithFields(logrus.Fields{"file": remoteFile.Path, "offset": written, "error": err}).Warn("Resuming interrupted download")
+
+		body, err = ranger.OpenRange(remoteFile.Path, remoteFile.ETag, written)
+		if err != nil {
+			break
 		}
+
+		var n int64
+		n, err = io.Copy(w, body)
+		body.Close()
+		written += n
 	}
 
+	if err != nil {
+		file.Close()
+		return err
+	}
+	// Write the file to the storage before it replaces the previous copy
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if remoteFile.Size >= 0 && written != remoteFile.Size {
+		return fmt.Errorf("%w: expected %d bytes, got %d", ErrSizeMismatch, remoteFile.Size, written)
+	}
+	if verifier != nil {
+		return verifier.Verify()
+	}
 	return nil
 }
 
@@ -94,79 +326,273 @@ func deleteFiles(files []string) error {
 	// Iterate over the list of files and delete them
 	for _, fileName := range files {
 		// Delete the file
-		fullPath := filepath.Join(consts.SyncDir, fileName)
+		fullPath := filepath.Join(profile.SyncDir, fileName)
 		if err := os.Remove(fullPath); err != nil {
 			return err
 		}
 
 		// Delete the directory if it's empty (and not the root directory)
-		dir := filepath.Dir(fullPath)
-		files, err := ioutil.ReadDir(dir)
-		if err != nil {
+		if err := removeEmptyDir(filepath.Dir(fullPath)); err != nil {
 			return err
 		}
-
-		if len(files) == 0 && dir != consts.SyncDir {
-			os.Remove(dir)
-		}
 	}
 
 	return nil
 }
 
-func init() {
-	logFilePath := consts.InternalDir + "/" + "kloud.log"
+// removeEmptyDir deletes a directory of the sync directory left empty
+func removeEmptyDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 && dir != profile.SyncDir {
+		os.Remove(dir)
+	}
+	return nil
+}
 
-	file, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+// openLog sends the logs to the log file of the internal directory, or to stderr if it cannot be opened
+func openLog() {
+	os.MkdirAll(filepath.Dir(logPath()), 0700)
+	file, err := os.OpenFile(logPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "Cannot open log file, logging to stderr: %v\n", err)
+		return
 	}
 
 	logger.Out = file
 }
 
-func main() {
+// syncPlan is what a sync has to do to bring the local library up to date
+type syncPlan struct {
+	unchanged   bool
+	conf        config.Config
+	remote      backend.Backend
+	syncState   state.State
+	version     string
+	remoteFiles map[string]backend.Entry
+	toDownload  []backend.Entry
+	toDelete    []string
+	progress    []backend.Entry
+}
+
+// planSync loads the configuration, the state and both listings, and computes what a sync has to do.
+// When skipUnchanged is set, it returns an unchanged plan without listings if the remote library did not change
+// since the last sync. The caller must close the backend of the plan.
+func planSync(skipUnchanged bool) (*syncPlan, error) {
 	// Start and read config
-	config, err := config.Get()
+	conf, err := config.Load(configFile())
 	if err != nil {
-		logger.WithField("error", err).Fatal("Cannot retrieve configuration")
-		os.Exit(1)
+		return nil, fmt.Errorf("cannot retrieve configuration: %w", err)
+	}
+	logger.Infof("Started with configuration: %+v", conf.Redacted())
+
+	// Load what the previous sync remembered
+	syncState, err := state.Load(statePath())
+	if err != nil {
+		return nil, fmt.Errorf("cannot read sync state: %w", err)
 	}
-	logger.Infof("Started with configuration: %+v", config)
 
 	// Get the list of files in the sync directory
-	localFiles, err := getLocalFiles(consts.SyncDir)
+	localFiles, err := getLocalFiles(profile.SyncDir)
 	if err != nil {
-		logger.WithField("error", err).Fatal("Cannot read local filesystem")
-		os.Exit(1)
+		return nil, fmt.Errorf("cannot read local filesystem: %w", err)
 	}
 	logger.WithField("local_files", localFiles).Info("Retrieved local files")
 
-	// Create the nextcloud client and use it to get the list of files in the server
-	ncClient, err := nextcloud.NewClient(cacert, config.Server, config.ShareID)
+	// Create the backend and use it to get the list of files in the remote library
+	remote, err := newBackend(conf)
 	if err != nil {
-		logger.WithField("error", err).Fatal("Impossible to create NextCloud client")
-		os.Exit(1)
+		return nil, fmt.Errorf("impossible to create backend: %w", err)
+	}
+	plan := &syncPlan{conf: conf, remote: remote}
+
+	// Skip the sync when nothing changed in the library since the last successful one, unless deletions were held
+	// back and may be due
+	if versioner, ok := remote.(backend.Versioner); ok {
+		plan.version, err = versioner.Version()
+		if err != nil {
+			logger.WithField("error", err).Warn("Cannot retrieve the version of the remote library")
+			plan.version = ""
+		}
+		if skipUnchanged && plan.version != "" && plan.version == syncState.Version && len(syncState.HeldBack) == 0 {
+			logger.WithField("version", plan.version).Info("Remote library did not change, nothing to do")
+			plan.unchanged, plan.syncState = true, syncState
+			return plan, nil
+		}
 	}
+	syncState.Version = ""
 
-	remoteFiles, err := ncClient.GetRemoteFiles()
+	plan.remoteFiles, err = getRemoteFiles(remote, &syncState)
 	if err != nil {
-		logger.WithField("error", err).Fatal("Cannot load remote NextCloud")
-		os.Exit(1)
+		closeBackend(remote)
+		return nil, fmt.Errorf("cannot load remote library: %w", err)
 	}
-	logger.WithField("remote_files", remoteFiles).Info("Retrieved remote files")
+	logger.WithField("remote_files", plan.remoteFiles).Info("Retrieved remote files")
 
-	// Compute the files to download and to delete, and download and deletes them
-	toDownload, toDelete := diffFiles(localFiles, remoteFiles)
-	logger.WithField("to_download", toDownload).Info("Files to download")
-	logger.WithField("to_delete", toDelete).Info("Files to delete")
+	excludeAnnotations(plan.remoteFiles, conf.Annotations)
+	plan.progress = excludeProgress(plan.remoteFiles, conf.Progress)
 
-	if err := downloadFiles(ncClient, toDownload); err != nil {
-		logger.WithField("error", err).Fatal("Failed to download files")
+	// Compute the files to download and to delete
+	plan.toDownload, plan.toDelete = diffFiles(localFiles, plan.remoteFiles, syncState)
+	logger.WithField("to_download", plan.toDownload).Info("Files to download")
+	logger.WithField("to_delete", plan.toDelete).Info("Files to delete")
+
+	plan.syncState = syncState
+	return plan, nil
+}
+
+// uploadedVersion returns the version of the remote library after kloud uploaded its exports, so the next sync does
+// not take them for remote changes. Changes made by others during the sync are only picked up once the library
+// changes again. The version is forgotten when it cannot be read.
+func uploadedVersion(remote backend.Backend) string {
+	versioner, ok := remote.(backend.Versioner)
+	if ok == false {
+		return ""
+	}
+
+	version, err := versioner.Version()
+	if err != nil {
+		logger.WithField("error", err).Warn("Cannot retrieve the version of the remote library")
+		return ""
+	}
+	return version
+}
+
+// closeBackend closes the connection of backends that keep one open
+func closeBackend(remote backend.Backend) {
+	if closer, ok := remote.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// runSync brings the local library up to date with the remote one
+func runSync(args []string) int {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	mountPoint := deviceFlag(flags)
+	flags.Parse(args)
+
+	if err := useDevice(*mountPoint); err != nil {
+		logger.WithField("error", err).Error("Cannot use device")
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	plan, err := planSync(true)
+	if errors.Is(err, backend.ErrUnauthorized) {
+		logger.WithField("error", err).Error("The server refused the credentials, check the credentials in config.yml")
+		fmt.Fprintf(os.Stderr, "The server refused the credentials, check the credentials in config.yml: %v\n", err)
+		return 1
+	}
+	if err != nil {
+		logger.WithField("error", err).Error("Cannot prepare sync")
+		fmt.Fprintf(os.Stderr, "Cannot prepare sync: %v\n", err)
+		return 1
 	}
-	if err := deleteFiles(toDelete); err != nil {
-		logger.WithField("error", err).Fatal("Failed to delete files")
+	defer closeBackend(plan.remote)
+	syncState := plan.syncState
+
+	// The database of Nickel is shared by the steps of the sync, to back it up once before the first of them
+	// changes it
+	db := openNickel()
+	defer closeNickel(db)
+
+	if plan.unchanged {
+		// Finish the updates of the library of Nickel left by the previous syncs, and export what was read since
+		if nickelPending(syncState) {
+			updateNickel(db, plan.conf.Nickel, &syncState, nil)
+		}
+		exported := exportAnnotations(db, plan.conf.Annotations, plan.remote, &syncState)
+		published := syncProgress(db, plan.conf.Progress, plan.remote, nil, &syncState)
+		if exported || published {
+			syncState.Version = uploadedVersion(plan.remote)
+		}
+		if err := syncState.Save(statePath()); err != nil {
+			logger.WithField("error", err).Error("Cannot save sync state")
+		}
+
+		// The reader may have lost the library since the last sync, when it rebooted
+		if err := profile.RefreshLibrary(false); err != nil {
+			logger.WithField("error", err).Error("Failed to refresh library")
+			fmt.Fprintf(os.Stderr, "Failed to refresh library: %v\n", err)
+			return 1
+		}
+		fmt.Println("The library is up to date")
+		return 0
+	}
+
+	// Download and delete the files. The state is saved even when a download fails to keep the versions
+	// of the files downloaded so far.
+	syncState.ETags = syncedETags(plan.remoteFiles, plan.toDownload)
+	rejected, downloadErr := downloadFiles(plan.remote, plan.toDownload, syncState.ETags)
+	if downloadErr != nil {
+		syncState.Redownload = append(syncState.Redownload, rejected...)
+	} else {
+		syncState.Redownload = rejected
+	}
+	if err := syncState.Save(statePath()); err != nil {
+		logger.WithField("error", err).Error("Cannot save sync state")
+	}
+	if downloadErr != nil {
+		logger.WithField("error", downloadErr).Error("Failed to download files")
+		fmt.Fprintf(os.Stderr, "Failed to download files: %v\n", downloadErr)
+		return 1
+	}
+
+	// Books being read are kept, delayed or archived instead of deleted when configured
+	deleted := protectReading(db, plan.conf.Reading, plan.toDelete, &syncState)
+	if err := deleteFiles(deleted.deleted); err != nil {
+		logger.WithField("error", err).Error("Failed to delete files")
+		fmt.Fprintf(os.Stderr, "Failed to delete files: %v\n", err)
+		return 1
+	}
+
+	// Remove the deleted books from the library of Nickel and update the collections
+	updateNickel(db, plan.conf.Nickel, &syncState, deleted.deleted)
+	exported := exportAnnotations(db, plan.conf.Annotations, plan.remote, &syncState)
+	published := syncProgress(db, plan.conf.Progress, plan.remote, plan.progress, &syncState)
+
+	// Only remember the version of the library once it is fully synced, so rejected files are downloaded again
+	version := plan.version
+	if version != "" && (exported || published) {
+		version = uploadedVersion(plan.remote)
+	}
+	if len(rejected) > 0 {
+		logger.WithField("rejected", rejected).Error("Some files were corrupted, they will be downloaded again on the next sync")
+		version = ""
+	}
+	syncState.Version = version
+	syncState.LastSuccess = time.Now()
+	if err := syncState.Save(statePath()); err != nil {
+		logger.WithField("error", err).Error("Cannot save sync state")
+	}
+
+	// Make the reader notice the new and deleted books, and write everything to devices about to be unplugged
+	changed := len(plan.toDownload) > 0 || len(deleted.deleted) > 0 || len(deleted.archived) > 0
+	logger.WithFields(logrus.Fields{"refresh": profile.Refresh, "changed": changed}).Info("Refreshing library")
+	if err := profile.RefreshLibrary(changed); err != nil {
+		logger.WithField("error", err).Error("Failed to refresh library")
+		fmt.Fprintf(os.Stderr, "Failed to refresh library: %v\n", err)
+		return 1
 	}
 
 	logger.Info("Success")
+	fmt.Printf("%d files downloaded, %d files deleted", len(plan.toDownload)-len(rejected), len(deleted.deleted))
+	if len(deleted.archived) > 0 {
+		fmt.Printf(", %d books being read archived", len(deleted.archived))
+	}
+	if len(deleted.held) > 0 {
+		fmt.Printf(", %d deletions of books being read held back", len(deleted.held))
+	}
+	fmt.Println()
+	for _, fileName := range deleted.held {
+		fmt.Printf("held back %s\n", fileName)
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"kloud/pkg/backend"
+	"kloud/pkg/state"
+)
+
+func TestIsNewer(t *testing.T) {
+	modTime := time.Date(2021, 5, 2, 20, 15, 11, 500000000, time.UTC)
+	tests := []struct {
+		remote, local time.Time
+		newer         bool
+	}{
+		{modTime, modTime, false},
+		// FAT rounds odd seconds down
+		{modTime, modTime.Truncate(2 * time.Second), false},
+		{modTime.Truncate(time.Second), modTime.Truncate(2 * time.Second), false},
+		{modTime.Add(time.Minute), modTime, true},
+		{modTime, modTime.Add(time.Minute), false},
+		{time.Time{}, modTime, false},
+	}
+	for _, test := range tests {
+		if newer := isNewer(backend.Entry{ModTime: test.remote}, backend.Entry{ModTime: test.local}); newer != test.newer {
+			t.Errorf("expected %v newer than %v: %v, got %v", test.remote, test.local, test.newer, newer)
+		}
+	}
+}
+
+func TestDiffFiles(t *testing.T) {
+	modTime := time.Date(2021, 5, 2, 20, 15, 11, 0, time.UTC)
+	stored := modTime.Add(-time.Second)
+
+	local := map[string]backend.Entry{
+		"same.epub":     {Path: "same.epub", Size: 10, ModTime: stored},
+		"resized.epub":  {Path: "resized.epub", Size: 10, ModTime: stored},
+		"updated.epub":  {Path: "updated.epub", Size: 10, ModTime: stored},
+		"retagged.epub": {Path: "retagged.epub", Size: 10, ModTime: stored},
+		"corrupt.epub":  {Path: "corrupt.epub", Size: 10, ModTime: stored},
+		"deleted.epub":  {Path: "deleted.epub", Size: 10, ModTime: stored},
+	}
+	remote := map[string]backend.Entry{
+		"same.epub":     {Path: "same.epub", Size: 10, ModTime: modTime, ETag: "a"},
+		"resized.epub":  {Path: "resized.epub", Size: 12, ModTime: modTime},
+		"updated.epub":  {Path: "updated.epub", Size: 10, ModTime: modTime.Add(time.Hour)},
+		"retagged.epub": {Path: "retagged.epub", Size: 10, ModTime: modTime, ETag: "c"},
+		"corrupt.epub":  {Path: "corrupt.epub", Size: 10, ModTime: modTime},
+		"new.epub":      {Path: "new.epub", Size: -1},
+	}
+	syncState := state.State{
+		ETags:      map[string]string{"same.epub": "a", "retagged.epub": "b"},
+		Redownload: []string{"corrupt.epub"},
+	}
+
+	toDownload, toDelete := diffFiles(local, remote, syncState)
+	var downloaded []string
+	for _, entry := range toDownload {
+		downloaded = append(downloaded, entry.Path)
+	}
+	sort.Strings(downloaded)
+
+	expected := []string{"corrupt.epub", "new.epub", "resized.epub", "retagged.epub", "updated.epub"}
+	if reflect.DeepEqual(downloaded, expected) == false {
+		t.Errorf("expected to download %v, got %v", expected, downloaded)
+	}
+	if reflect.DeepEqual(toDelete, []string{"deleted.epub"}) == false {
+		t.Errorf("expected to delete deleted.epub, got %v", toDelete)
+	}
+}
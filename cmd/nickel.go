@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"kloud/pkg/config"
+	"kloud/pkg/nickel"
+	"kloud/pkg/state"
+
+	"github.com/sirupsen/logrus"
+)
+
+// nickelBackup is the name of the backup of the database of Nickel taken before kloud updates it
+const nickelBackup = "KoboReader.sqlite.bak"
+
+// openNickel opens the database of Nickel for a whole sync, so that it is backed up once before the first change
+// of the sync. It returns nil when the profile has no database or it cannot be opened.
+func openNickel() *nickel.DB {
+	if profile.Database == "" {
+		return nil
+	}
+
+	db, err := nickel.Open(profile.Database)
+	if err != nil {
+		logger.WithField("error", err).Warn("Cannot open the database of Nickel, it will be updated on the next sync")
+		return nil
+	}
+	db.SetBackup(filepath.Join(profile.InternalDir, nickelBackup))
+	return db
+}
+
+// closeNickel closes the database of Nickel opened by openNickel
+func closeNickel(db *nickel.DB) {
+	if db != nil {
+		db.Close()
+	}
+}
+
+// nickelPending reports whether the library of Nickel has updates left from the previous syncs
+func nickelPending(syncState state.State) bool {
+	return len(syncState.NickelCleanup) > 0 || syncState.CollectionsPending
+}
+
+// updateNickel brings the library of Nickel up to date with the sync: the deleted files are removed from it and
+// the collections follow the folders. The database is left alone while Nickel holds it or when it cannot be opened,
+// in which case the updates are left for the next sync.
+func updateNickel(db *nickel.DB, conf config.Nickel, syncState *state.State, deleted []string) {
+	cleanup := append(syncState.NickelCleanup, deleted...)
+	if conf.Cleanup == false {
+		cleanup = nil
+	}
+	syncState.NickelCleanup = cleanup
+	if (len(cleanup) == 0 && conf.CollectionDepth <= 0) || db == nil {
+		return
+	}
+
+	var err error
+	if len(cleanup) > 0 {
+		err = cleanupNickel(db, syncState)
+	}
+	if err == nil && conf.CollectionDepth > 0 {
+		err = updateCollections(db, conf.CollectionDepth, syncState)
+	}
+
+	if errors.Is(err, nickel.ErrLocked) {
+		logger.Warn("Nickel holds its database, it will be updated on the next sync")
+		syncState.CollectionsPending = conf.CollectionDepth > 0
+	} else if err != nil {
+		logger.WithField("error", err).Error("Cannot update the database of Nickel, it will be retried on the next sync")
+		syncState.CollectionsPending = conf.CollectionDepth > 0
+	}
+}
+
+// cleanupNickel removes the deleted files from the library of Nickel, which otherwise keeps them until it rescans
+// the whole storage
+func cleanupNickel(db *nickel.DB, syncState *state.State) error {
+	var paths []string
+	for _, fileName := range syncState.NickelCleanup {
+		paths = append(paths, profile.NickelPaths(filepath.Join(profile.SyncDir, filepath.FromSlash(fileName)))...)
+	}
+
+	removed, err := db.RemoveBooks(paths)
+	if err != nil {
+		return err
+	}
+
+	logger.WithField("removed", removed).Info("Removed deleted books from the library of Nickel")
+	syncState.NickelCleanup = nil
+	return nil
+}
+
+// updateCollections creates a collection for each folder at depth in the sync directory, holding the books below it
+func updateCollections(db *nickel.DB, depth int, syncState *state.State) error {
+	localFiles, err := getLocalFiles(profile.SyncDir)
+	if err != nil {
+		return err
+	}
+
+	collections := map[string][]nickel.Book{}
+	for fileName := range localFiles {
+		folders := strings.Split(fileName, "/")
+		folders = folders[:len(folders)-1]
+		if len(folders) < depth {
+			continue
+		}
+
+		name := folders[depth-1]
+		book := profile.NickelPaths(filepath.Join(profile.SyncDir, filepath.FromSlash(fileName)))
+		collections[name] = append(collections[name], book)
+	}
+
+	result, err := db.SyncCollections(collections, syncState.Collections, profile.NickelPaths(profile.SyncDir))
+	if err != nil {
+		return err
+	}
+
+	// Books are only added once Nickel imported them, after the library is refreshed
+	logger.WithFields(logrus.Fields{"collections": result.Managed, "unknown": result.Unknown}).Info("Updated collections")
+	syncState.Collections = result.Managed
+	syncState.CollectionsPending = result.Unknown > 0
+	return nil
+}
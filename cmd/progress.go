@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"kloud/pkg/backend"
+	"kloud/pkg/config"
+	"kloud/pkg/device"
+	"kloud/pkg/nickel"
+	"kloud/pkg/state"
+
+	"github.com/sirupsen/logrus"
+)
+
+// progressDocument is the reading progress of a device, published on the remote library
+type progressDocument struct {
+	Device string `json:"device"`
+	// Books maps the path of the books in the library to their progress
+	Books map[string]bookProgress `json:"books"`
+}
+
+// bookProgress is how far a book was read on a device
+type bookProgress struct {
+	Status      string `json:"status"`
+	PercentRead int    `json:"percent_read"`
+	LastRead    string `json:"last_read"`
+}
+
+// Names of the reading statuses of Nickel in the progress documents
+var statusNames = map[int]string{
+	nickel.StatusUnread:   "unread",
+	nickel.StatusReading:  "reading",
+	nickel.StatusFinished: "finished",
+}
+
+// progressName returns the name of the progress document of this device. Kobos all share the same host name, so
+// they are named after their serial number by default, read from the storage holding the database of Nickel.
+func progressName(conf config.Progress) string {
+	name := conf.Name
+	if name == "" && profile.Database != "" {
+		name, _ = device.Serial(filepath.Dir(filepath.Dir(profile.Database)))
+	}
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	if name == "" {
+		name = profile.Name
+	}
+	return backend.SanitizeName(name) + ".json"
+}
+
+// excludeProgress removes the progress documents from a remote listing, they are not part of the library, and
+// returns the documents of the other devices
+func excludeProgress(remoteFiles map[string]backend.Entry, conf config.Progress) []backend.Entry {
+	folder := strings.Trim(conf.Folder, "/")
+	if folder == "" {
+		return nil
+	}
+
+	own := path.Join(folder, progressName(conf))
+	var documents []backend.Entry
+	for fileName, entry := range remoteFiles {
+		if strings.HasPrefix(fileName, folder+"/") == false {
+			continue
+		}
+
+		delete(remoteFiles, fileName)
+		if fileName != own && path.Dir(fileName) == folder && path.Ext(fileName) == ".json" {
+			documents = append(documents, entry)
+		}
+	}
+	return documents
+}
+
+// syncProgress imports the progress of the books read more recently on other devices when enabled, then publishes
+// the progress of this device, when it changed since the last sync. It reports whether the remote library was
+// modified.
+func syncProgress(db *nickel.DB, conf config.Progress, remote backend.Backend, documents []backend.Entry, syncState *state.State) bool {
+	folder := strings.Trim(conf.Folder, "/")
+	if folder == "" || db == nil {
+		return false
+	}
+
+	localFiles, err := getLocalFiles(profile.SyncDir)
+	if err != nil {
+		logger.WithField("error", err).Error("Cannot read local filesystem, the progress is not synced")
+		return false
+	}
+
+	if conf.Import && len(documents) > 0 {
+		err := importProgress(db, remote, documents, localFiles)
+		if errors.Is(err, nickel.ErrLocked) {
+			logger.Warn("Nickel holds its database, the progress of the other devices will be imported on the next sync")
+		} else if err != nil {
+			logger.WithField("error", err).Error("Cannot import the progress of the other devices")
+		}
+	}
+
+	published, err := publishProgress(db, conf, folder, remote, localFiles, syncState)
+	if err != nil {
+		logger.WithField("error", err).Warn("Cannot publish the progress, it will be published on the next sync")
+	}
+	return published
+}
+
+// publishProgress uploads the progress document of this device when it changed, and reports whether it did
+func publishProgress(db *nickel.DB, conf config.Progress, folder string, remote backend.Backend, localFiles map[string]backend.Entry, syncState *state.State) (bool, error) {
+	uploader, ok := remote.(backend.Uploader)
+	if ok == false {
+		logger.Warn("The backend cannot upload files, the progress is not published")
+		return false, nil
+	}
+
+	progress, err := db.Progress(profile.NickelPaths(profile.SyncDir))
+	if err != nil {
+		return false, err
+	}
+
+	document := progressDocument{Device: strings.TrimSuffix(progressName(conf), ".json"), Books: map[string]bookProgress{}}
+	for fileName := range localFiles {
+		for _, nickelPath := range profile.NickelPaths(filepath.Join(profile.SyncDir, filepath.FromSlash(fileName))) {
+			if p, ok := progress[nickelPath]; ok && p.LastRead != "" {
+				document.Books[fileName] = bookProgress{Status: statusNames[p.Status], PercentRead: p.PercentRead, LastRead: p.LastRead}
+			}
+		}
+	}
+
+	content, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	if syncState.Progress == hash {
+		return false, nil
+	}
+
+	fileName := path.Join(folder, progressName(conf))
+	if err := uploader.Upload(fileName, bytes.NewReader(content)); err != nil {
+		return false, err
+	}
+	logger.WithFields(logrus.Fields{"file": fileName, "books": len(document.Books)}).Info("Published the progress")
+	syncState.Progress = hash
+	return true, nil
+}
+
+// importProgress sets the progress of the books read more recently on another device than on this one
+func importProgress(db *nickel.DB, remote backend.Backend, documents []backend.Entry, localFiles map[string]backend.Entry) error {
+	// Keep the most recent progress of each synced book
+	newest := map[string]bookProgress{}
+	for _, entry := range documents {
+		document, err := readProgress(remote, entry.Path)
+		if err != nil {
+			logger.WithFields(logrus.Fields{"file": entry.Path, "error": err}).Warn("Cannot read progress document")
+			continue
+		}
+
+		for fileName, p := range document.Books {
+			if _, ok := localFiles[fileName]; ok == false || p.LastRead == "" {
+				continue
+			}
+			if p.LastRead > newest[fileName].LastRead {
+				newest[fileName] = p
+			}
+		}
+	}
+
+	local, err := db.Progress(profile.NickelPaths(profile.SyncDir))
+	if err != nil {
+		return err
+	}
+
+	// Only the books Nickel imported can be updated. Nickel writes dates in a single format, so they compare as strings.
+	imports := map[string]nickel.Progress{}
+	for fileName, p := range newest {
+		status := nickel.StatusReading
+		for value, name := range statusNames {
+			if name == p.Status {
+				status = value
+			}
+		}
+
+		paths := profile.NickelPaths(filepath.Join(profile.SyncDir, filepath.FromSlash(fileName)))
+		for _, nickelPath := range paths {
+			current, ok := local[nickelPath]
+			if ok == false || current.LastRead >= p.LastRead {
+				continue
+			}
+			imports[nickelPath] = nickel.Progress{Status: status, PercentRead: p.PercentRead, LastRead: p.LastRead}
+		}
+	}
+	if len(imports) == 0 {
+		return nil
+	}
+
+	updated, err := db.ImportProgress(imports)
+	if err != nil {
+		return err
+	}
+
+	logger.WithField("updated", updated).Info("Imported the progress of the other devices")
+	return nil
+}
+
+// readProgress downloads and decodes the progress document of another device
+func readProgress(remote backend.Backend, fileName string) (progressDocument, error) {
+	r, err := remote.Open(fileName)
+	if err != nil {
+		return progressDocument{}, err
+	}
+	defer r.Close()
+
+	var document progressDocument
+	if err := json.NewDecoder(r).Decode(&document); err != nil {
+		return progressDocument{}, err
+	}
+	return document, nil
+}
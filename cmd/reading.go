@@ -0,0 +1,179 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"kloud/pkg/config"
+	"kloud/pkg/nickel"
+	"kloud/pkg/state"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of the protection of the books being read
+const (
+	defaultDelayDays = 7
+	defaultArchive   = "Archive"
+)
+
+// deletions is what becomes of the files deleted from the remote library
+type deletions struct {
+	// deleted lists the files to delete from the device
+	deleted []string
+	// held lists the files kept on the device because they are being read
+	held []string
+	// archived lists the files moved to the archive of the device
+	archived []string
+}
+
+// protectReading takes the books being read out of the files to delete, as configured. They are kept until they
+// are no longer read, kept for a delay, or moved to the archive folder of the device along with their progress.
+// When kloud cannot tell which books are being read, all the deletions are held back until the next sync.
+func protectReading(db *nickel.DB, conf config.Reading, toDelete []string, syncState *state.State) deletions {
+	if conf.Protect == "" || conf.Protect == config.ProtectDelete || profile.Database == "" || len(toDelete) == 0 {
+		syncState.HeldBack = nil
+		return deletions{deleted: toDelete}
+	}
+
+	if db == nil {
+		logger.Warn("The database of Nickel is not open, deletions are held back until the next sync")
+		return holdAll(toDelete, syncState)
+	}
+
+	progress, err := db.Progress(profile.NickelPaths(profile.SyncDir))
+	if err != nil {
+		logger.WithField("error", err).Warn("Cannot read the progress of the books, deletions are held back until the next sync")
+		return holdAll(toDelete, syncState)
+	}
+
+	var since time.Time
+	if conf.RecentDays > 0 {
+		since = time.Now().AddDate(0, 0, -conf.RecentDays)
+	}
+	delay := conf.DelayDays
+	if delay == 0 {
+		delay = defaultDelayDays
+	}
+
+	var result deletions
+	var toArchive []string
+	heldBack := map[string]time.Time{}
+	for _, fileName := range toDelete {
+		reading := false
+		for _, nickelPath := range profile.NickelPaths(filepath.Join(profile.SyncDir, filepath.FromSlash(fileName))) {
+			reading = reading || progress[nickelPath].Reading(since)
+		}
+		if reading == false {
+			result.deleted = append(result.deleted, fileName)
+			continue
+		}
+
+		switch conf.Protect {
+		case config.ProtectArchive:
+			toArchive = append(toArchive, fileName)
+			continue
+		case config.ProtectDelay:
+			first, ok := syncState.HeldBack[fileName]
+			if ok && time.Since(first) >= time.Duration(delay)*24*time.Hour {
+				result.deleted = append(result.deleted, fileName)
+				continue
+			}
+		}
+
+		result.held = append(result.held, fileName)
+		if first, ok := syncState.HeldBack[fileName]; ok {
+			heldBack[fileName] = first
+		} else {
+			heldBack[fileName] = time.Now()
+		}
+	}
+
+	if len(toArchive) > 0 {
+		var failed []string
+		result.archived, failed = archiveBooks(db, valueOr(conf.Archive, defaultArchive), toArchive)
+		for _, fileName := range failed {
+			result.held = append(result.held, fileName)
+			heldBack[fileName] = time.Now()
+		}
+	}
+
+	if len(result.held) > 0 {
+		logger.WithField("held", result.held).Info("Deletion of books being read held back")
+	}
+	syncState.HeldBack = heldBack
+	return result
+}
+
+// holdAll holds back all the deletions until the next sync
+func holdAll(toDelete []string, syncState *state.State) deletions {
+	heldBack := map[string]time.Time{}
+	for _, fileName := range toDelete {
+		if first, ok := syncState.HeldBack[fileName]; ok {
+			heldBack[fileName] = first
+		} else {
+			heldBack[fileName] = time.Now()
+		}
+	}
+	syncState.HeldBack = heldBack
+	return deletions{held: toDelete}
+}
+
+// archiveBooks moves books from the sync directory to the archive folder of the device, and updates the library
+// of Nickel so they keep their progress. The books are moved back when Nickel cannot be updated. It returns the
+// books archived and the ones that failed.
+func archiveBooks(db *nickel.DB, folder string, files []string) (archived, failed []string) {
+	archiveDir := filepath.Join(profile.MountPoint, filepath.FromSlash(folder))
+	if rel, err := filepath.Rel(profile.SyncDir, archiveDir); err != nil || strings.HasPrefix(rel, "..") == false {
+		logger.WithField("archive", archiveDir).Error("The archive folder cannot be in the sync directory, books are kept instead")
+		return nil, files
+	}
+
+	moves := map[string]string{}
+	for _, fileName := range files {
+		from := filepath.Join(profile.SyncDir, filepath.FromSlash(fileName))
+		to := filepath.Join(archiveDir, filepath.FromSlash(fileName))
+
+		err := os.MkdirAll(filepath.Dir(to), 0755)
+		if err == nil {
+			err = os.Rename(from, to)
+		}
+		if err != nil {
+			logger.WithFields(logrus.Fields{"file": fileName, "error": err}).Error("Cannot archive book, it is kept instead")
+			failed = append(failed, fileName)
+			continue
+		}
+
+		// Each path Nickel may know the book by goes to the same path of the destination, or to its main one when
+		// the destination has no such path, as the archive outside of the mirror of the sync directory
+		if toPaths := profile.NickelPaths(to); len(toPaths) > 0 {
+			for i, fromPath := range profile.NickelPaths(from) {
+				moves[fromPath] = toPaths[0]
+				if i < len(toPaths) {
+					moves[fromPath] = toPaths[i]
+				}
+			}
+		}
+		archived = append(archived, fileName)
+	}
+
+	var err error
+	if len(moves) > 0 {
+		_, err = db.MoveBooks(moves)
+	}
+	if err != nil {
+		logger.WithField("error", err).Warn("Cannot update the database of Nickel, books are kept until the next sync")
+		for _, fileName := range archived {
+			os.Rename(filepath.Join(archiveDir, filepath.FromSlash(fileName)), filepath.Join(profile.SyncDir, filepath.FromSlash(fileName)))
+		}
+		return nil, append(failed, archived...)
+	}
+
+	for _, fileName := range archived {
+		removeEmptyDir(filepath.Dir(filepath.Join(profile.SyncDir, filepath.FromSlash(fileName))))
+	}
+	logger.WithFields(logrus.Fields{"archived": archived, "archive": archiveDir}).Info("Archived books being read")
+	return archived, failed
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"kloud/pkg/config"
+	"kloud/pkg/device"
+	"kloud/pkg/state"
+)
+
+// useFixtureDevice makes the profile a Kobo in a temporary directory, with the books of the fixture of Nickel
+func useFixtureDevice(t *testing.T) {
+	root := t.TempDir()
+	previous := profile
+	t.Cleanup(func() { profile = previous })
+
+	profile = device.Profile{
+		Name:        device.NameKobo,
+		MountPoint:  root,
+		SyncDir:     filepath.Join(root, "KloudSync"),
+		InternalDir: filepath.Join(root, ".kloud"),
+		Database:    filepath.Join(root, ".kobo", "KoboReader.sqlite"),
+		NickelRoot:  device.KoboMountPoint,
+	}
+
+	for _, dir := range []string{profile.InternalDir, filepath.Dir(profile.Database)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, fileName := range []string{"Herbert/Dune.kepub.epub", "Asimov/Foundation.epub"} {
+		fullPath := filepath.Join(profile.SyncDir, filepath.FromSlash(fileName))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeArchive(t, fullPath)
+	}
+
+	schema, err := ioutil.ReadFile(filepath.Join("..", "pkg", "nickel", "testdata", "KoboReader.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", profile.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProtectReading(t *testing.T) {
+	toDelete := []string{"Herbert/Dune.kepub.epub", "Asimov/Foundation.epub"}
+
+	// Dune is being read
+	useFixtureDevice(t)
+	db := openNickel()
+	defer closeNickel(db)
+	var syncState state.State
+	result := protectReading(db, config.Reading{Protect: config.ProtectKeep}, toDelete, &syncState)
+	if reflect.DeepEqual(result, deletions{deleted: toDelete[1:], held: toDelete[:1]}) == false {
+		t.Errorf("unexpected deletions %+v", result)
+	}
+	if _, ok := syncState.HeldBack["Herbert/Dune.kepub.epub"]; ok == false || len(syncState.HeldBack) != 1 {
+		t.Errorf("expected Dune to be held back, got %+v", syncState.HeldBack)
+	}
+
+	// The delay of Dune is over
+	syncState.HeldBack["Herbert/Dune.kepub.epub"] = time.Now().AddDate(0, 0, -10)
+	result = protectReading(db, config.Reading{Protect: config.ProtectDelay}, toDelete, &syncState)
+	if reflect.DeepEqual(result, deletions{deleted: toDelete}) == false {
+		t.Errorf("unexpected deletions %+v", result)
+	}
+	if len(syncState.HeldBack) != 0 {
+		t.Errorf("expected no deletions held back, got %+v", syncState.HeldBack)
+	}
+
+	// Foundation was never opened, even recently
+	result = protectReading(db, config.Reading{Protect: config.ProtectDelay, RecentDays: 7}, toDelete[1:], &syncState)
+	if reflect.DeepEqual(result, deletions{deleted: toDelete[1:]}) == false {
+		t.Errorf("unexpected deletions %+v", result)
+	}
+}
+
+func TestProtectReadingArchive(t *testing.T) {
+	useFixtureDevice(t)
+	db := openNickel()
+	defer closeNickel(db)
+	var syncState state.State
+	result := protectReading(db, config.Reading{Protect: config.ProtectArchive}, []string{"Herbert/Dune.kepub.epub"}, &syncState)
+	if reflect.DeepEqual(result, deletions{archived: []string{"Herbert/Dune.kepub.epub"}}) == false {
+		t.Fatalf("unexpected deletions %+v", result)
+	}
+
+	if _, err := os.Stat(filepath.Join(profile.MountPoint, "Archive", "Herbert", "Dune.kepub.epub")); err != nil {
+		t.Errorf("expected the book in the archive: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(profile.SyncDir, "Herbert")); os.IsNotExist(err) == false {
+		t.Errorf("expected the folder of the book to be removed: %v", err)
+	}
+	// The following updates of the sync keep the backup taken before the first one
+	updateNickel(db, config.Nickel{Cleanup: true}, &syncState, []string{"Asimov/Foundation.epub"})
+	backup, err := sql.Open("sqlite", filepath.Join(profile.InternalDir, nickelBackup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	var books int
+	err = backup.QueryRow("SELECT COUNT(*) FROM content WHERE ContentID IN ('file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub', 'file:///mnt/onboard/KloudSync/Asimov/Foundation.epub')").Scan(&books)
+	if err != nil || books != 2 {
+		t.Errorf("expected a backup of the database before the sync, got %d books: %v", books, err)
+	}
+
+	sqlDB, err := sql.Open("sqlite", profile.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	var percent int
+	err = sqlDB.QueryRow("SELECT ___PercentRead FROM content WHERE ContentID = 'file:///mnt/onboard/Archive/Herbert/Dune.kepub.epub'").Scan(&percent)
+	if err != nil || percent != 42 {
+		t.Errorf("expected the archived book to keep its progress, got %d: %v", percent, err)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"kloud/pkg/backend"
+	"kloud/pkg/checksum"
+	"kloud/pkg/config"
+	"kloud/pkg/state"
+)
+
+// Problems found by the verify command
+const (
+	problemMissing  = "missing"
+	problemSize     = "size"
+	problemChecksum = "checksum"
+	problemArchive  = "archive"
+)
+
+// archiveExtensions are the extensions of the formats that are zip archives
+var archiveExtensions = []string{".epub", ".cbz"}
+
+// problem is a local file that does not match the remote library
+type problem struct {
+	Path    string `json:"path"`
+	Problem string `json:"problem"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// verifyReport is the result of the verify command
+type verifyReport struct {
+	Checked   int       `json:"checked"`
+	Problems  []problem `json:"problems"`
+	Scheduled bool      `json:"scheduled"`
+}
+
+// runVerify audits the local library against a fresh listing of the remote one and returns the exit code.
+// With -fix, the files found corrupted are downloaded again by the next sync.
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	jsonReport := flags.Bool("json", false, "Print the report as JSON")
+	fix := flags.Bool("fix", false, "Download the mismatching files again on the next sync")
+	mountPoint := deviceFlag(flags)
+	flags.Parse(args)
+
+	if err := useDevice(*mountPoint); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	report, err := verify(*fix)
+	if err != nil {
+		logger.WithField("error", err).Error("Cannot verify library")
+		fmt.Fprintf(os.Stderr, "Cannot verify library: %v\n", err)
+		return 2
+	}
+
+	if *jsonReport {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		for _, p := range report.Problems {
+			fmt.Printf("%s: %s %s\n", p.Path, p.Problem, p.Detail)
+		}
+		fmt.Printf("%d files checked, %d problems\n", report.Checked, len(report.Problems))
+		if report.Scheduled {
+			fmt.Println("The mismatching files will be downloaded again on the next sync")
+		}
+	}
+
+	if len(report.Problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// verify compares the local library with the remote one
+func verify(fix bool) (verifyReport, error) {
+	conf, err := config.Load(configFile())
+	if err != nil {
+		return verifyReport{}, err
+	}
+
+	syncState, err := state.Load(statePath())
+	if err != nil {
+		return verifyReport{}, err
+	}
+
+	localFiles, err := getLocalFiles(profile.SyncDir)
+	if err != nil {
+		return verifyReport{}, err
+	}
+
+	remote, err := newBackend(conf)
+	if err != nil {
+		return verifyReport{}, err
+	}
+	defer closeBackend(remote)
+
+	// List the whole library, whatever the previous sync remembered
+	remoteFiles, err := getRemoteFiles(remote, &state.State{})
+	if err != nil {
+		return verifyReport{}, err
+	}
+	excludeAnnotations(remoteFiles, conf.Annotations)
+	excludeProgress(remoteFiles, conf.Progress)
+
+	report := verifyReport{Problems: []problem{}}
+	for fileName, remoteFile := range remoteFiles {
+		localFile, ok := localFiles[fileName]
+		if ok == false {
+			report.Problems = append(report.Problems, problem{Path: fileName, Problem: problemMissing})
+			continue
+		}
+
+		report.Checked++
+		fullPath := filepath.Join(profile.SyncDir, filepath.FromSlash(fileName))
+		if p, ok := checkFile(fullPath, localFile, remoteFile); ok == false {
+			report.Problems = append(report.Problems, p)
+		}
+	}
+	sort.Slice(report.Problems, func(i, j int) bool { return report.Problems[i].Path < report.Problems[j].Path })
+	logger.WithField("problems", report.Problems).Info("Verified library")
+
+	// Missing files are downloaded by the next sync anyway, corrupted ones need to be scheduled once
+	if fix && len(report.Problems) > 0 {
+		scheduled := map[string]bool{}
+		for _, fileName := range syncState.Redownload {
+			scheduled[fileName] = true
+		}
+		for _, p := range report.Problems {
+			if p.Problem != problemMissing && scheduled[p.Path] == false {
+				syncState.Redownload = append(syncState.Redownload, p.Path)
+				scheduled[p.Path] = true
+			}
+		}
+		syncState.Version = ""
+		if err := syncState.Save(statePath()); err != nil {
+			return verifyReport{}, err
+		}
+		report.Scheduled = true
+	}
+
+	return report, nil
+}
+
+// checkFile checks the size and checksum of a local file against the remote one, and that archives can be read
+func checkFile(fullPath string, localFile, remoteFile backend.Entry) (problem, bool) {
+	if remoteFile.Size >= 0 && localFile.Size != remoteFile.Size {
+		return problem{Path: remoteFile.Path, Problem: problemSize, Detail: fmt.Sprintf("expected %d bytes, got %d", remoteFile.Size, localFile.Size)}, false
+	}
+
+	if remoteFile.Checksum != "" {
+		if err := checkChecksum(fullPath, remoteFile.Checksum); err != nil {
+			return problem{Path: remoteFile.Path, Problem: problemChecksum, Detail: err.Error()}, false
+		}
+	}
+
+	for _, extension := range archiveExtensions {
+		if strings.HasSuffix(strings.ToLower(fullPath), extension) {
+			if err := checkArchive(fullPath); err != nil {
+				return problem{Path: remoteFile.Path, Problem: problemArchive, Detail: err.Error()}, false
+			}
+		}
+	}
+
+	return problem{}, true
+}
+
+// checkChecksum hashes a local file and compares it with a checksum such as SHA1:<hex>
+func checkChecksum(fullPath, expected string) error {
+	verifier, err := checksum.NewVerifier(expected)
+	if err != nil {
+		// Checksums kloud cannot compute are not a problem of the file
+		return nil
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(verifier, file); err != nil {
+		return err
+	}
+	return verifier.Verify()
+}
+
+// checkArchive reads every file of a zip archive, which checks their CRC-32
+func checkArchive(fullPath string) error {
+	archive, err := zip.OpenReader(fullPath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+
+		_, err = io.Copy(ioutil.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"kloud/pkg/backend"
+)
+
+func writeArchive(t *testing.T, fullPath string) {
+	file, err := os.Create(fullPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	w, _ := archive.Create("mimetype")
+	w.Write([]byte("application/epub+zip"))
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.epub")
+	writeArchive(t, valid)
+	info, _ := os.Stat(valid)
+	local := backend.Entry{Path: "valid.epub", Size: info.Size()}
+
+	if p, ok := checkFile(valid, local, backend.Entry{Path: "valid.epub", Size: -1}); ok == false {
+		t.Errorf("unexpected problem %+v", p)
+	}
+	if p, _ := checkFile(valid, local, backend.Entry{Path: "valid.epub", Size: info.Size() + 1}); p.Problem != problemSize {
+		t.Errorf("expected a size problem, got %+v", p)
+	}
+	if p, _ := checkFile(valid, local, backend.Entry{Path: "valid.epub", Size: -1, Checksum: "MD5:098f6bcd4621d373cade4e832627b4f6"}); p.Problem != problemChecksum {
+		t.Errorf("expected a checksum problem, got %+v", p)
+	}
+
+	// Truncate the archive as an interrupted copy would
+	truncated := filepath.Join(dir, "truncated.cbz")
+	content, _ := os.ReadFile(valid)
+	os.WriteFile(truncated, content[:len(content)/2], 0600)
+	if p, _ := checkFile(truncated, local, backend.Entry{Path: "truncated.cbz", Size: -1}); p.Problem != problemArchive {
+		t.Errorf("expected an archive problem, got %+v", p)
+	}
+
+	// Other formats are not expected to be archives
+	pdf := filepath.Join(dir, "book.pdf")
+	os.WriteFile(pdf, []byte("test"), 0600)
+	if p, ok := checkFile(pdf, local, backend.Entry{Path: "book.pdf", Size: -1, Checksum: "MD5:098f6bcd4621d373cade4e832627b4f6"}); ok == false {
+		t.Errorf("unexpected problem %+v", p)
+	}
+}
@@ -3,6 +3,9 @@ module kloud
 go 1.16
 
 require (
+	github.com/pkg/sftp v1.13.4
 	github.com/sirupsen/logrus v1.8.0
+	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b
 	gopkg.in/yaml.v2 v2.4.0
+	modernc.org/sqlite v1.14.2
 )
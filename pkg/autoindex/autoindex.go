@@ -0,0 +1,179 @@
+// Package autoindex syncs a library from the directory listings of a static web server, such as nginx with
+// autoindex or Apache with mod_autoindex.
+package autoindex
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"kloud/pkg/backend"
+)
+
+const (
+	// maxConcurrentHeads is the maximum number of HEAD requests in flight while listing the files
+	maxConcurrentHeads = 4
+	// maxDepth protects the crawl against servers exposing a never-ending hierarchy
+	maxDepth = 64
+	// maxListingSize protects the crawl against links to huge files that look like directories
+	maxListingSize = 16 << 20
+)
+
+// Errors returned when crawling the listings
+var (
+	ErrTooDeep = errors.New("remote directory is nested too deeply")
+)
+
+// Client crawls the directory listings of a static web server, such as nginx with autoindex or Apache
+type Client struct {
+	http     http.Client
+	root     *url.URL
+	user     string
+	password string
+}
+
+// Client only reads the listings, which carry no checksums nor versions
+var _ backend.Backend = (*Client)(nil)
+
+// NewClient creates a new client for the directory listed at rootURL with the configured TLS settings.
+// user may be empty for servers that do not require authentication.
+func NewClient(cacert []byte, rootURL, user, password string) (*Client, error) {
+	httpClient, err := backend.NewHTTPClient(cacert)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := url.Parse(rootURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Relative links of the listing are resolved against the directory itself
+	if strings.HasSuffix(root.Path, "/") == false {
+		root.Path += "/"
+		root.RawPath = ""
+	}
+
+	return &Client{http: httpClient, root: root, user: user, password: password}, nil
+}
+
+// List crawls the listings breadth-first, then calls fn for every file with the Content-Length, Last-Modified
+// and ETag headers returned by a HEAD request on it.
+func (c *Client) List(fn func(backend.Entry) error) error {
+	var (
+		files   []*url.URL
+		visited = map[string]bool{c.root.Path: true}
+		level   = []*url.URL{c.root}
+	)
+
+	for depth := 0; len(level) > 0; depth++ {
+		if depth > maxDepth {
+			return ErrTooDeep
+		}
+
+		var next []*url.URL
+		for _, dir := range level {
+			links, err := c.listing(dir)
+			if err != nil {
+				return err
+			}
+
+			for _, l := range links {
+				if l.dir == false {
+					files = append(files, l.url)
+				} else if visited[l.url.Path] == false {
+					visited[l.url.Path] = true
+					next = append(next, l.url)
+				}
+			}
+		}
+		level = next
+	}
+
+	// Retrieve the metadata of the files, with a limited number of concurrent requests
+	entries := make([]backend.Entry, len(files))
+	errs := make([]error, len(files))
+	sem := make(chan struct{}, maxConcurrentHeads)
+	var wg sync.WaitGroup
+
+	for i, file := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, file *url.URL) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			entries[i], errs[i] = c.head(file)
+		}(i, file)
+	}
+	wg.Wait()
+
+	for i := range entries {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if err := fn(entries[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Open downloads a file and returns a stream of its contents
+func (c *Client) Open(filePath string) (io.ReadCloser, error) {
+	resp, err := c.do("GET", c.fileURL(filePath))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// listing downloads and parses the listing of a directory
+func (c *Client) listing(dir *url.URL) ([]link, error) {
+	resp, err := c.do("GET", dir)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxListingSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseListing(dir, resp.Header, body)
+}
+
+// head retrieves the metadata of a file
+func (c *Client) head(file *url.URL) (backend.Entry, error) {
+	resp, err := c.do("HEAD", file)
+	if err != nil {
+		return backend.Entry{}, err
+	}
+	resp.Body.Close()
+
+	entry := backend.Entry{
+		Path: strings.TrimPrefix(file.Path, c.root.Path),
+		Size: resp.ContentLength,
+		ETag: resp.Header.Get("ETag"),
+	}
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		entry.ModTime = lastModified
+	}
+	return entry, nil
+}
+
+// fileURL returns the URL of a file from its path relative to the root directory
+func (c *Client) fileURL(filePath string) *url.URL {
+	return c.root.ResolveReference(&url.URL{Path: filePath})
+}
+
+// do performs an authenticated request and checks its status
+func (c *Client) do(method string, target *url.URL) (*http.Response, error) {
+	return backend.Do(&c.http, method, target.String(), c.user, c.password)
+}
@@ -0,0 +1,145 @@
+package autoindex
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kloud/pkg/backend"
+)
+
+// Listings of nginx in HTML, nginx in JSON, and Apache
+var listings = map[string]string{
+	"/books/": `<html><head><title>Index of /books/</title></head><body><h1>Index of /books/</h1><hr><pre>
+<a href="../">../</a>
+<a href="Herbert/">Herbert/</a>                                           28-Nov-2021 18:30       -
+<a href="Tolkien/">Tolkien/</a>                                           28-Nov-2021 18:30       -
+<a href="Dune%20Messiah.epub">Dune Messiah.epub</a>                       28-Nov-2021 18:30      20
+<a href="Notes &amp; drafts.pdf">Notes &amp; drafts.pdf</a>               28-Nov-2021 18:30       8
+</pre><hr></body></html>`,
+	"/books/Herbert/": `[
+{ "name":"Children of Dune.epub", "type":"file", "mtime":"Sun, 28 Nov 2021 18:30:15 GMT", "size":20 },
+{ "name":"Drafts", "type":"directory", "mtime":"Sun, 28 Nov 2021 18:30:15 GMT" }
+]`,
+	"/books/Herbert/Drafts/": `[]`,
+	"/books/Tolkien/": `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 3.2 Final//EN">
+<html><head><title>Index of /books/Tolkien</title></head><body><h1>Index of /books/Tolkien</h1>
+<table>
+<tr><th><a href="?C=N;O=D">Name</a></th><th><a href="?C=M;O=A">Last modified</a></th></tr>
+<tr><td><a href="/books/">Parent Directory</a></td></tr>
+<tr><td><a href='The%20Hobbit.epub'>The Hobbit.epub</a></td></tr>
+<tr><td><a href="https://apache.org/">Apache</a></td></tr>
+</table></body></html>`,
+}
+
+func newTestServer(methods *[]string) (*httptest.Server, []byte) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*methods = append(*methods, r.Method+" "+r.URL.Path)
+
+		if user, password, _ := r.BasicAuth(); user != "alice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if listing, ok := listings[r.URL.Path]; ok {
+			if strings.HasPrefix(listing, "[") {
+				w.Header().Set("Content-Type", "application/json")
+			}
+			fmt.Fprint(w, listing)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/books/") == false || strings.HasSuffix(r.URL.Path, "/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		content := "The spice must flow."
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.Header().Set("Last-Modified", "Sun, 28 Nov 2021 18:30:15 GMT")
+		w.Header().Set("ETag", `"61a3cb17-14"`)
+		if r.Method == "GET" {
+			fmt.Fprint(w, content)
+		}
+	})
+
+	srv := httptest.NewTLSServer(handler)
+	return srv, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+}
+
+func TestList(t *testing.T) {
+	var methods []string
+	srv, cacert := newTestServer(&methods)
+	defer srv.Close()
+
+	client, err := NewClient(cacert, srv.URL+"/books", "alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []backend.Entry
+	err = client.List(func(entry backend.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Date(2021, time.November, 28, 18, 30, 15, 0, time.UTC)
+	expected := []backend.Entry{
+		{Path: "Dune Messiah.epub", Size: 20, ModTime: modTime, ETag: `"61a3cb17-14"`},
+		{Path: "Notes & drafts.pdf", Size: 20, ModTime: modTime, ETag: `"61a3cb17-14"`},
+		{Path: "Herbert/Children of Dune.epub", Size: 20, ModTime: modTime, ETag: `"61a3cb17-14"`},
+		{Path: "Tolkien/The Hobbit.epub", Size: 20, ModTime: modTime, ETag: `"61a3cb17-14"`},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %v", len(expected), entries)
+	}
+	for i := range expected {
+		if entries[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], entries[i])
+		}
+	}
+
+	// Parent, sorting and external links are not followed
+	for _, method := range methods {
+		if method == "GET /" || strings.Contains(method, "apache") {
+			t.Errorf("unexpected request %s", method)
+		}
+	}
+
+	body, err := client.Open("Notes & drafts.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(body)
+	body.Close()
+	if string(content) != "The spice must flow." {
+		t.Errorf("unexpected content %q", content)
+	}
+	if last := methods[len(methods)-1]; last != "GET /books/Notes & drafts.pdf" {
+		t.Errorf("unexpected request %s", last)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	var methods []string
+	srv, cacert := newTestServer(&methods)
+	defer srv.Close()
+
+	client, err := NewClient(cacert, srv.URL+"/books/", "alice", "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.List(func(backend.Entry) error { return nil })
+	if errors.Is(err, backend.ErrUnauthorized) == false {
+		t.Errorf("expected backend.ErrUnauthorized, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package autoindex
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// hrefPattern matches the links of an HTML directory listing
+var hrefPattern = regexp.MustCompile(`(?i)<a\s[^>]*href\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+// jsonEntry is an entry of an nginx listing with autoindex_format json
+type jsonEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// link is an entry of a directory listing, resolved against the URL of the listing
+type link struct {
+	url *url.URL
+	dir bool
+}
+
+// parseListing returns the entries of a directory listing in the nginx JSON format, or in HTML as served by nginx,
+// Apache and most static servers. Links leading outside of the listed directory, such as the parent directory or
+// Apache sorting links, are skipped.
+func parseListing(base *url.URL, header http.Header, body []byte) ([]link, error) {
+	var refs []*url.URL
+
+	if strings.HasPrefix(header.Get("Content-Type"), "application/json") || bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		var entries []jsonEntry
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			ref := &url.URL{Path: e.Name}
+			if e.Type == "directory" {
+				ref.Path += "/"
+			}
+			refs = append(refs, ref)
+		}
+	} else {
+		for _, match := range hrefPattern.FindAllSubmatch(body, -1) {
+			href := html.UnescapeString(string(match[1]) + string(match[2]))
+			ref, err := url.Parse(href)
+			if err != nil || ref.RawQuery != "" {
+				continue
+			}
+			refs = append(refs, ref)
+		}
+	}
+
+	var links []link
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		target := base.ResolveReference(ref)
+		target.Fragment = ""
+		if isChild(base, target) == false || seen[target.Path] {
+			continue
+		}
+		seen[target.Path] = true
+
+		links = append(links, link{url: target, dir: strings.HasSuffix(target.Path, "/")})
+	}
+
+	return links, nil
+}
+
+// isChild reports whether target is inside the directory listed at base
+func isChild(base, target *url.URL) bool {
+	return target.Scheme == base.Scheme && target.Host == base.Host &&
+		strings.HasPrefix(target.Path, base.Path) && len(target.Path) > len(base.Path)
+}
@@ -0,0 +1,98 @@
+package backend
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Entry is a file of a remote library, with the metadata used to detect changes
+type Entry struct {
+	// Path is relative to the root of the library, with slash separators
+	Path string
+	// Size is -1 when the backend does not know it
+	Size int64
+	// ModTime is the zero time when the backend does not know it
+	ModTime time.Time
+	// ETag is an opaque version of the content that changes with it, empty when the backend does not provide one
+	ETag string
+	// Checksum is the checksum of the content as ALGORITHM:hex, such as SHA1:<hex>, empty when it is unknown
+	Checksum string
+}
+
+// Errors returned by the optional capabilities of backends
+var (
+	ErrInvalidToken = errors.New("sync token was rejected by the server")
+	ErrNotSupported = errors.New("operation is not supported by the server")
+)
+
+// Change is a file created, modified or deleted since a previous listing
+type Change struct {
+	Entry
+	// Deleted is set when the file, or the folder at Path with everything it contained, was removed
+	Deleted bool
+}
+
+// Backend is a remote library kloud syncs from
+type Backend interface {
+	// List calls fn for every file of the library and stops at the first error fn returns
+	List(fn func(Entry) error) error
+	// Open returns a stream of the content of a file, which the caller must close
+	Open(path string) (io.ReadCloser, error)
+}
+
+// RangeOpener is implemented by backends that can resume an interrupted download
+type RangeOpener interface {
+	// OpenRange returns a stream of the content of a file from offset. When etag is not empty,
+	// it fails if the file changed since it was listed instead of mixing two versions.
+	OpenRange(path, etag string, offset int64) (io.ReadCloser, error)
+}
+
+// ChangeLister is implemented by backends that can list what changed since a previous listing
+type ChangeLister interface {
+	// ListChanges calls fn for every change since the listing identified by token, or for every file when token
+	// is empty, and returns the token of the current listing. It fails with ErrInvalidToken when token expired.
+	ListChanges(token string, fn func(Change) error) (string, error)
+}
+
+// Versioner is implemented by backends that can tell cheaply whether anything changed in the library
+type Versioner interface {
+	// Version returns an opaque value that changes whenever a file of the library changes
+	Version() (string, error)
+}
+
+// Uploader is implemented by backends that can write files
+type Uploader interface {
+	// Upload creates or replaces a file with the content of r, creating parent folders as needed
+	Upload(path string, r io.Reader) error
+}
+
+// Mover is implemented by backends that can move files
+type Mover interface {
+	// Move renames a file, replacing the destination if it exists
+	Move(from, to string) error
+}
+
+// Deleter is implemented by backends that can delete files
+type Deleter interface {
+	// Delete removes a file
+	Delete(path string) error
+}
+
+// SanitizeName turns a title into a file or folder name that is valid on the FAT filesystem of the device
+func SanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	// Names cannot end with a dot or a space
+	name = strings.TrimRight(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "_"
+	}
+	return name
+}
@@ -0,0 +1,69 @@
+package backend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors returned by the HTTP helpers and the HTTP backends
+var (
+	ErrUnableToAppendCerts = errors.New("unable to append certificates to pool")
+	ErrUnauthorized        = errors.New("credentials are wrong or missing")
+)
+
+// StatusError is returned when a server answers with an unexpected HTTP status code
+type StatusError struct {
+	StatusCode int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// NewHTTPClient creates an HTTP client trusting the certificates of cacert, the device having no CA store
+func NewHTTPClient(cacert []byte) (http.Client, error) {
+	caCertPool := x509.NewCertPool()
+	ok := caCertPool.AppendCertsFromPEM(cacert)
+	if ok == false {
+		return http.Client{}, ErrUnableToAppendCerts
+	}
+
+	return http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
+			},
+		},
+	}, nil
+}
+
+// Do performs a request, authenticated with basic auth when user is not empty. It returns the response when the
+// server answers 200 OK, ErrUnauthorized when it rejects the credentials and a StatusError otherwise.
+func Do(client *http.Client, method, rawURL, user, password string) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusUnauthorized:
+		resp.Body.Close()
+		return nil, ErrUnauthorized
+	default:
+		resp.Body.Close()
+		return nil, StatusError{StatusCode: resp.StatusCode}
+	}
+}
@@ -0,0 +1,235 @@
+// Package calibre syncs a library from a Calibre content server, listing the books through its AJAX API and
+// downloading one format of each.
+package calibre
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"kloud/pkg/backend"
+)
+
+// pageSize is the number of books requested at once from the server
+var pageSize = 100
+
+// Errors returned when downloading a book
+var (
+	ErrUnknownBook = errors.New("book was not found in the library")
+)
+
+// Formats kloud can download, by order of preference, with their file extension
+var formats = []struct {
+	name      string
+	extension string
+}{
+	{"kepub", ".kepub.epub"},
+	{"epub", ".epub"},
+	{"pdf", ".pdf"},
+}
+
+// Formats the server rewrites with the current metadata of the book when downloading them, so their stored size
+// is not the one of the download
+var rewrittenFormats = map[string]bool{
+	"epub": true,
+	"azw3": true,
+	"mobi": true,
+}
+
+// book is the metadata of a book returned by the /ajax/books endpoint
+type book struct {
+	Title          string   `json:"title"`
+	Authors        []string `json:"authors"`
+	Formats        []string `json:"formats"`
+	LastModified   string   `json:"last_modified"`
+	FormatMetadata map[string]struct {
+		Size int64 `json:"size"`
+	} `json:"format_metadata"`
+}
+
+// Client lists and downloads the books of a library of a Calibre content server
+type Client struct {
+	http     http.Client
+	server   string
+	library  string
+	user     string
+	password string
+	query    string
+
+	// books maps the path of the books found by List to their download URL
+	books map[string]string
+}
+
+// Client only lists and downloads, the library is never written to
+var _ backend.Backend = (*Client)(nil)
+
+// NewClient creates a new Calibre client for a library of the server with the configured TLS settings.
+// The books can be restricted to a saved search, to a tag, or both. An empty library selects the default one.
+func NewClient(cacert []byte, server, library, user, password, savedSearch, tag string) (*Client, error) {
+	httpClient, err := backend.NewHTTPClient(cacert)
+	if err != nil {
+		return nil, err
+	}
+
+	var terms []string
+	if savedSearch != "" {
+		terms = append(terms, fmt.Sprintf("search:%q", savedSearch))
+	}
+	if tag != "" {
+		terms = append(terms, fmt.Sprintf("tags:%q", "="+tag))
+	}
+
+	return &Client{
+		http:     httpClient,
+		server:   strings.TrimSuffix(server, "/"),
+		library:  library,
+		user:     user,
+		password: password,
+		query:    strings.Join(terms, " and "),
+		books:    map[string]string{},
+	}, nil
+}
+
+// List calls fn for every book of the library available in a supported format, as Author/Title (id).ext.
+// The last modification of the book in Calibre, which includes metadata edits, is used as its ModTime.
+func (c *Client) List(fn func(backend.Entry) error) error {
+	c.books = map[string]string{}
+
+	for offset := 0; ; offset += pageSize {
+		// Find the next page of books matching the query
+		search := struct {
+			TotalNum int   `json:"total_num"`
+			BookIDs  []int `json:"book_ids"`
+		}{}
+		query := url.Values{
+			"query":  {c.query},
+			"num":    {strconv.Itoa(pageSize)},
+			"offset": {strconv.Itoa(offset)},
+			"sort":   {"id"},
+		}
+		if err := c.getJSON(c.endpoint("/ajax/search", query), &search); err != nil {
+			return err
+		}
+		if len(search.BookIDs) == 0 {
+			return nil
+		}
+
+		// Retrieve the metadata of the page of books
+		ids := make([]string, len(search.BookIDs))
+		for i, id := range search.BookIDs {
+			ids[i] = strconv.Itoa(id)
+		}
+		books := map[string]*book{}
+		if err := c.getJSON(c.endpoint("/ajax/books", url.Values{"ids": {strings.Join(ids, ",")}}), &books); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			b := books[id]
+			if b == nil {
+				continue
+			}
+
+			entry, ok := c.entry(id, b)
+			if ok == false {
+				continue
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+
+		if offset+pageSize >= search.TotalNum {
+			return nil
+		}
+	}
+}
+
+// entry picks the preferred format of a book and registers its download URL
+func (c *Client) entry(id string, b *book) (backend.Entry, bool) {
+	available := map[string]string{}
+	for _, format := range b.Formats {
+		available[strings.ToLower(format)] = format
+	}
+
+	for _, format := range formats {
+		name, ok := available[format.name]
+		if ok == false {
+			continue
+		}
+
+		author := "Unknown"
+		if len(b.Authors) > 0 {
+			author = b.Authors[0]
+		}
+		folder := backend.SanitizeName(author)
+		title := backend.SanitizeName(b.Title)
+
+		// Two books can share the same author and title, tell them apart with their id so that their paths do not
+		// depend on the other books of the library
+		bookPath := path.Join(folder, fmt.Sprintf("%s (%s)%s", title, id, format.extension))
+		c.books[bookPath] = c.endpoint("/get/"+url.PathEscape(name)+"/"+id, nil)
+
+		// Changes of rewritten formats are only told by the last modification of the book
+		entry := backend.Entry{Path: bookPath, Size: -1}
+		for metadataFormat, metadata := range b.FormatMetadata {
+			if strings.EqualFold(metadataFormat, format.name) && metadata.Size > 0 && rewrittenFormats[format.name] == false {
+				entry.Size = metadata.Size
+			}
+		}
+		if lastModified, err := time.Parse(time.RFC3339, b.LastModified); err == nil {
+			entry.ModTime = lastModified
+		}
+		return entry, true
+	}
+
+	return backend.Entry{}, false
+}
+
+// Open downloads a book found by List and returns a stream of its contents
+func (c *Client) Open(bookPath string) (io.ReadCloser, error) {
+	href, ok := c.books[bookPath]
+	if ok == false {
+		return nil, ErrUnknownBook
+	}
+
+	resp, err := c.get(href)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// endpoint returns the URL of an endpoint of the server for the library
+func (c *Client) endpoint(endpoint string, query url.Values) string {
+	if c.library != "" {
+		endpoint += "/" + url.PathEscape(c.library)
+	}
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	return c.server + endpoint
+}
+
+// getJSON performs a GET request and decodes its JSON response into v
+func (c *Client) getJSON(rawURL string, v interface{}) error {
+	resp, err := c.get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// get performs an authenticated GET request and checks its status
+func (c *Client) get(rawURL string) (*http.Response, error) {
+	return backend.Do(&c.http, "GET", rawURL, c.user, c.password)
+}
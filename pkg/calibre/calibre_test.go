@@ -0,0 +1,140 @@
+package calibre
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kloud/pkg/backend"
+)
+
+func newTestServer(queries *[]string) (*httptest.Server, []byte) {
+	metadata := map[string]string{
+		"1": `{"title":"Dune","authors":["Frank Herbert"],"formats":["EPUB","KEPUB"],"last_modified":"2021-11-28T18:30:15+00:00","format_metadata":{"kepub":{"size":42},"epub":{"size":41}}}`,
+		"2": `{"title":"Dune","authors":["Frank Herbert"],"formats":["PDF","KEPUB"],"last_modified":"2021-11-29T10:00:00+00:00"}`,
+		"3": `{"title":"Notes: draft","authors":[],"formats":["EPUB"]}`,
+		"4": `{"title":"Scan","authors":["Nobody"],"formats":["CBZ"]}`,
+		"5": `{"title":"Children of Dune","authors":["Frank Herbert"],"formats":["EPUB"],"format_metadata":{"epub":{"size":5}}}`,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ajax/search/books", func(w http.ResponseWriter, r *http.Request) {
+		*queries = append(*queries, r.URL.Query().Get("query"))
+
+		switch r.URL.Query().Get("offset") {
+		case "0":
+			fmt.Fprint(w, `{"total_num":5,"book_ids":[1,2]}`)
+		case "2":
+			fmt.Fprint(w, `{"total_num":5,"book_ids":[3,4]}`)
+		case "4":
+			fmt.Fprint(w, `{"total_num":5,"book_ids":[5]}`)
+		default:
+			fmt.Fprint(w, `{"total_num":5,"book_ids":[]}`)
+		}
+	})
+	mux.HandleFunc("/ajax/books/books", func(w http.ResponseWriter, r *http.Request) {
+		var books []string
+		for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+			books = append(books, fmt.Sprintf("%q:%s", id, metadata[id]))
+		}
+		fmt.Fprintf(w, "{%s}", strings.Join(books, ","))
+	})
+	mux.HandleFunc("/get/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	})
+
+	srv := httptest.NewTLSServer(mux)
+	return srv, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+}
+
+func TestList(t *testing.T) {
+	var queries []string
+	srv, cacert := newTestServer(&queries)
+	defer srv.Close()
+
+	client, err := NewClient(cacert, srv.URL+"/", "books", "", "", "To read", "sci-fi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Serve the books two by two to exercise pagination
+	pageSize = 2
+	defer func() { pageSize = 100 }()
+
+	var entries []backend.Entry
+	err = client.List(func(entry backend.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []backend.Entry{
+		{Path: "Frank Herbert/Dune (1).kepub.epub", Size: 42, ModTime: time.Date(2021, time.November, 28, 18, 30, 15, 0, time.UTC)},
+		{Path: "Frank Herbert/Dune (2).kepub.epub", Size: -1, ModTime: time.Date(2021, time.November, 29, 10, 0, 0, 0, time.UTC)},
+		{Path: "Unknown/Notes_ draft (3).epub", Size: -1},
+		{Path: "Frank Herbert/Children of Dune (5).epub", Size: -1},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, entries)
+	}
+	for i := range expected {
+		if entries[i].Path != expected[i].Path || entries[i].Size != expected[i].Size || entries[i].ModTime.Equal(expected[i].ModTime) == false {
+			t.Errorf("expected %+v, got %+v", expected[i], entries[i])
+		}
+	}
+
+	if queries[0] != `search:"To read" and tags:"=sci-fi"` {
+		t.Errorf("unexpected query %s", queries[0])
+	}
+
+	body, err := client.Open("Frank Herbert/Dune (1).kepub.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	content, _ := ioutil.ReadAll(body)
+	if string(content) != "/get/KEPUB/1/books" {
+		t.Errorf("unexpected content %s", content)
+	}
+}
+
+func TestRewrittenFormatSize(t *testing.T) {
+	var queries []string
+	srv, cacert := newTestServer(&queries)
+	defer srv.Close()
+
+	client, err := NewClient(cacert, srv.URL, "books", "", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageSize = 2
+	defer func() { pageSize = 100 }()
+
+	entries := map[string]backend.Entry{}
+	err = client.List(func(entry backend.Entry) error {
+		entries[entry.Path] = entry
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server writes the metadata into the EPUB it serves, making it longer than the stored file
+	entry := entries["Frank Herbert/Children of Dune (5).epub"]
+	body, err := client.Open(entry.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	content, _ := ioutil.ReadAll(body)
+	if entry.Size >= 0 && int64(len(content)) != entry.Size {
+		t.Errorf("the download of %d bytes does not match the listed size %d", len(content), entry.Size)
+	}
+}
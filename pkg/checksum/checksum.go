@@ -0,0 +1,81 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"strings"
+)
+
+// Errors returned by the package
+var (
+	ErrUnknownAlgorithm = errors.New("unknown checksum algorithm")
+	ErrMismatch         = errors.New("checksum does not match")
+)
+
+// Algorithms supported by the package, by order of preference, with the names NextCloud gives them
+var algorithms = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"SHA256", sha256.New},
+	{"SHA1", sha1.New},
+	{"MD5", md5.New},
+	{"ADLER32", func() hash.Hash { return adler32.New() }},
+}
+
+// Best returns the strongest supported checksum of a space separated list such as "SHA1:<hex> MD5:<hex>",
+// the format of the oc:checksum property of NextCloud, or an empty string if none is supported
+func Best(checksums string) string {
+	available := map[string]string{}
+	for _, checksum := range strings.Fields(checksums) {
+		parts := strings.SplitN(checksum, ":", 2)
+		if len(parts) == 2 {
+			available[strings.ToUpper(parts[0])] = strings.ToLower(parts[1])
+		}
+	}
+
+	for _, algorithm := range algorithms {
+		if digest, ok := available[algorithm.name]; ok {
+			return algorithm.name + ":" + digest
+		}
+	}
+	return ""
+}
+
+// Verifier computes the checksum of the content written to it and compares it with the expected one
+type Verifier struct {
+	hash     hash.Hash
+	expected string
+}
+
+// NewVerifier creates a verifier for a checksum such as "SHA1:<hex>"
+func NewVerifier(checksum string) (*Verifier, error) {
+	parts := strings.SplitN(checksum, ":", 2)
+	if len(parts) == 2 {
+		for _, algorithm := range algorithms {
+			if strings.EqualFold(algorithm.name, parts[0]) {
+				return &Verifier{hash: algorithm.new(), expected: strings.ToLower(parts[1])}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, checksum)
+}
+
+// Write adds content to the checksum
+func (v *Verifier) Write(p []byte) (int, error) {
+	return v.hash.Write(p)
+}
+
+// Verify checks the checksum of the content written so far
+func (v *Verifier) Verify() error {
+	if actual := hex.EncodeToString(v.hash.Sum(nil)); actual != v.expected {
+		return fmt.Errorf("%w: expected %s, got %s", ErrMismatch, v.expected, actual)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package checksum
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBest(t *testing.T) {
+	checksums := "SHA1:A94A8FE5CCB19BA61C4C0873D391E987982FBBD3 MD5:098f6bcd4621d373cade4e832627b4f6 ADLER32:045d01c1"
+	if best := Best(checksums); best != "SHA1:a94a8fe5ccb19ba61c4c0873d391e987982fbbd3" {
+		t.Errorf("unexpected checksum %s", best)
+	}
+	if best := Best("CRC32:d87f7e0c"); best != "" {
+		t.Errorf("unexpected checksum %s", best)
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	for _, checksum := range []string{
+		"SHA256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		"SHA1:a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+		"md5:098F6BCD4621D373CADE4E832627B4F6",
+		"ADLER32:045d01c1",
+	} {
+		verifier, err := NewVerifier(checksum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(verifier, strings.NewReader("test"))
+		if err := verifier.Verify(); err != nil {
+			t.Errorf("%s: %v", checksum, err)
+		}
+	}
+
+	verifier, _ := NewVerifier("SHA1:a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	io.Copy(verifier, strings.NewReader("tset"))
+	if err := verifier.Verify(); errors.Is(err, ErrMismatch) == false {
+		t.Errorf("expected ErrMismatch, got %v", err)
+	}
+
+	if _, err := NewVerifier("CRC32:d87f7e0c"); errors.Is(err, ErrUnknownAlgorithm) == false {
+		t.Errorf("expected ErrUnknownAlgorithm, got %v", err)
+	}
+}
@@ -5,20 +5,215 @@ import (
 	"io/ioutil"
 	"strings"
 
-	"kloud/pkg/consts"
+	"kloud/pkg/device"
 
 	"gopkg.in/yaml.v2"
 )
 
 // Config represents the configuration structure
 type Config struct {
-	Server  string `yaml:"server"`
-	ShareID string `yaml:"share"`
+	Type      string `yaml:"type"`
+	Mode      string `yaml:"mode"`
+	Server    string `yaml:"server"`
+	ShareID   string `yaml:"share"`
+	User      string `yaml:"user"`
+	Folder    string `yaml:"folder"`
+	Password  string `yaml:"password"`
+	Traversal string `yaml:"traversal"`
+
+	WebDAV    WebDAV    `yaml:"webdav"`
+	OPDS      OPDS      `yaml:"opds"`
+	Calibre   Calibre   `yaml:"calibre"`
+	S3        S3        `yaml:"s3"`
+	SFTP      SFTP      `yaml:"sftp"`
+	Autoindex Autoindex `yaml:"autoindex"`
+
+	Device      Device      `yaml:"device"`
+	Nickel      Nickel      `yaml:"nickel"`
+	Annotations Annotations `yaml:"annotations"`
+	Progress    Progress    `yaml:"progress"`
+	Reading     Reading     `yaml:"reading"`
+}
+
+// WebDAV is the configuration of a generic WebDAV backend
+type WebDAV struct {
+	URL      string `yaml:"url"`
+	Auth     string `yaml:"auth"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Token    string `yaml:"token"`
+}
+
+// OPDS is the configuration of an OPDS catalog backend
+type OPDS struct {
+	URL      string `yaml:"url"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Folders  string `yaml:"folders"`
+}
+
+// Calibre is the configuration of a Calibre content server backend
+type Calibre struct {
+	URL         string `yaml:"url"`
+	Library     string `yaml:"library"`
+	User        string `yaml:"user"`
+	Password    string `yaml:"password"`
+	SavedSearch string `yaml:"saved_search"`
+	Tag         string `yaml:"tag"`
+}
+
+// S3 is the configuration of an S3-compatible object storage backend
+type S3 struct {
+	Endpoint    string `yaml:"endpoint"`
+	Region      string `yaml:"region"`
+	Bucket      string `yaml:"bucket"`
+	Prefix      string `yaml:"prefix"`
+	AccessKey   string `yaml:"access_key"`
+	SecretKey   string `yaml:"secret_key"`
+	VirtualHost bool   `yaml:"virtual_host"`
+}
+
+// SFTP is the configuration of an SFTP backend.
+// The private key is a file of the internal directory, next to the known_hosts file trusting the server.
+type SFTP struct {
+	Host   string `yaml:"host"`
+	User   string `yaml:"user"`
+	Key    string `yaml:"key"`
+	Folder string `yaml:"folder"`
+}
+
+// Autoindex is the configuration of a backend crawling the directory listings of a static web server
+type Autoindex struct {
+	URL      string `yaml:"url"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+// Device overrides the profile of the device kloud syncs to, detected when empty
+type Device struct {
+	Profile string `yaml:"profile"`
+	SyncDir string `yaml:"sync_dir"`
+	Refresh string `yaml:"refresh"`
+}
+
+// Nickel is the configuration of the updates of the database of Nickel, the reading software of the Kobo
+type Nickel struct {
+	// Cleanup removes the books kloud deletes from the library of Nickel
+	Cleanup bool `yaml:"cleanup"`
+	// CollectionDepth creates a collection for each folder at this depth of the library, 0 to disable
+	CollectionDepth int `yaml:"collection_depth"`
+}
+
+// Annotations is the configuration of the export of the highlights and notes taken on the device
+type Annotations struct {
+	// Folder of the library the annotations are uploaded to, empty to disable the export
+	Folder string `yaml:"folder"`
+	// Format of the exported files, markdown (the default) or json
+	Format string `yaml:"format"`
+}
+
+// Progress is the configuration of the sync of the reading progress between devices
+type Progress struct {
+	// Folder of the library the progress of each device is uploaded to, empty to disable the sync
+	Folder string `yaml:"folder"`
+	// Name of the document of the device in the folder, the serial number of Kobos and the host name otherwise by
+	// default
+	Name string `yaml:"name"`
+	// Import takes the progress of the other devices for the books they read more recently
+	Import bool `yaml:"import"`
+}
+
+// Reading is the configuration of the protection from deletion of the books being read
+type Reading struct {
+	// Protect is what happens to the books being read deleted from the library: delete (the default), keep, archive
+	// or delay
+	Protect string `yaml:"protect"`
+	// RecentDays also protects the books opened in the last days, 0 to only protect the books marked as reading
+	RecentDays int `yaml:"recent_days"`
+	// DelayDays is how long the deletion is delayed by in delay mode, 7 days by default
+	DelayDays int `yaml:"delay_days"`
+	// Archive is the folder of the device the books are moved to in archive mode, Archive by default
+	Archive string `yaml:"archive"`
+}
+
+// Protections of the books being read
+const (
+	ProtectDelete  = "delete"
+	ProtectKeep    = "keep"
+	ProtectArchive = "archive"
+	ProtectDelay   = "delay"
+)
+
+// Formats of the exported annotations
+const (
+	FormatMarkdown = "markdown"
+	FormatJSON     = "json"
+)
+
+// Authentication methods of the WebDAV backend
+const (
+	AuthNone   = "none"
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+)
+
+// Types of backends kloud can sync from
+const (
+	// TypeNextCloud syncs from a NextCloud server, the default
+	TypeNextCloud = "nextcloud"
+	// TypeWebDAV syncs from a collection of any WebDAV server
+	TypeWebDAV = "webdav"
+	// TypeOPDS syncs from an OPDS catalog
+	TypeOPDS = "opds"
+	// TypeCalibre syncs from a library of a Calibre content server
+	TypeCalibre = "calibre"
+	// TypeS3 syncs from a bucket of an S3-compatible object storage
+	TypeS3 = "s3"
+	// TypeSFTP syncs from a folder of an SSH server
+	TypeSFTP = "sftp"
+	// TypeAutoindex syncs from a directory listed by a static web server
+	TypeAutoindex = "autoindex"
+)
+
+// Modes used to reach the NextCloud server
+const (
+	// ModeShare syncs a public share link, optionally protected by a password
+	ModeShare = "share"
+	// ModeAccount syncs a folder of a user account, authenticated with an app password
+	ModeAccount = "account"
+)
+
+// redacted replaces secrets in Redacted configurations
+const redacted = "<redacted>"
+
+// Redacted returns a copy of the configuration with its secrets hidden, suitable for logging
+func (config Config) Redacted() Config {
+	for _, secret := range []*string{&config.Password, &config.WebDAV.Password, &config.WebDAV.Token, &config.OPDS.Password, &config.Calibre.Password, &config.S3.SecretKey, &config.Autoindex.Password} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	return config
 }
 
 // Errors returned by the ValidateConfig func
 var (
-	ErrMissingScheme = errors.New("missing scheme (http or https) in server")
+	ErrUnknownType     = errors.New("unknown backend type")
+	ErrMissingScheme   = errors.New("missing scheme (http or https) in server")
+	ErrUnknownMode     = errors.New("unknown mode, expected share or account")
+	ErrMissingShare    = errors.New("missing share in share mode")
+	ErrMissingUser     = errors.New("missing user")
+	ErrMissingPassword = errors.New("missing app password in account mode")
+	ErrUnknownAuth     = errors.New("unknown auth, expected none, basic or bearer")
+	ErrMissingToken    = errors.New("missing token for bearer auth")
+	ErrMissingBucket   = errors.New("missing bucket")
+	ErrMissingSecret   = errors.New("missing secret key for the access key")
+	ErrMissingHost     = errors.New("missing host")
+	ErrNegativeDepth   = errors.New("negative collection depth")
+	ErrUnknownFormat   = errors.New("unknown annotations format, expected markdown or json")
+	ErrInvalidName     = errors.New("invalid progress name, it cannot contain slashes")
+	ErrUnknownProtect  = errors.New("unknown protection, expected delete, keep, archive or delay")
+	ErrNegativeDays    = errors.New("negative number of days")
 )
 
 func parseConfig(configFilePath string, config *Config) error {
@@ -36,17 +231,146 @@ func parseConfig(configFilePath string, config *Config) error {
 }
 
 func validateConfig(config Config) error {
-	if strings.HasPrefix(config.Server, "https") == false &&
-		strings.HasPrefix(config.Server, "http") == false {
+	if err := validateDevice(config.Device); err != nil {
+		return err
+	}
+	if config.Nickel.CollectionDepth < 0 {
+		return ErrNegativeDepth
+	}
+	switch config.Annotations.Format {
+	case "", FormatMarkdown, FormatJSON:
+	default:
+		return ErrUnknownFormat
+	}
+	if strings.ContainsAny(config.Progress.Name, `/\`) {
+		return ErrInvalidName
+	}
+	switch config.Reading.Protect {
+	case "", ProtectDelete, ProtectKeep, ProtectArchive, ProtectDelay:
+	default:
+		return ErrUnknownProtect
+	}
+	if config.Reading.RecentDays < 0 || config.Reading.DelayDays < 0 {
+		return ErrNegativeDays
+	}
+
+	switch config.Type {
+	case "", TypeNextCloud:
+		return validateNextCloud(config)
+	case TypeWebDAV:
+		return validateWebDAV(config.WebDAV)
+	case TypeOPDS:
+		return validateScheme(config.OPDS.URL)
+	case TypeCalibre:
+		return validateScheme(config.Calibre.URL)
+	case TypeS3:
+		return validateS3(config.S3)
+	case TypeSFTP:
+		return validateSFTP(config.SFTP)
+	case TypeAutoindex:
+		return validateScheme(config.Autoindex.URL)
+	default:
+		return ErrUnknownType
+	}
+}
+
+func validateScheme(url string) error {
+	if strings.HasPrefix(url, "https") == false &&
+		strings.HasPrefix(url, "http") == false {
 		return ErrMissingScheme
 	}
 
 	return nil
 }
 
-// Get parses and validate the configuration before retuning it to the caller
-func Get() (config Config, err error) {
-	if err := parseConfig(consts.InternalDir+"/config.yml", &config); err != nil {
+func validateNextCloud(config Config) error {
+	if err := validateScheme(config.Server); err != nil {
+		return err
+	}
+
+	switch config.Mode {
+	case "", ModeShare:
+		if config.ShareID == "" {
+			return ErrMissingShare
+		}
+	case ModeAccount:
+		if config.User == "" {
+			return ErrMissingUser
+		}
+		if config.Password == "" {
+			return ErrMissingPassword
+		}
+	default:
+		return ErrUnknownMode
+	}
+
+	return nil
+}
+
+func validateWebDAV(config WebDAV) error {
+	if err := validateScheme(config.URL); err != nil {
+		return err
+	}
+
+	switch config.Auth {
+	case "", AuthNone:
+	case AuthBasic:
+		if config.User == "" {
+			return ErrMissingUser
+		}
+	case AuthBearer:
+		if config.Token == "" {
+			return ErrMissingToken
+		}
+	default:
+		return ErrUnknownAuth
+	}
+
+	return nil
+}
+
+func validateS3(config S3) error {
+	if err := validateScheme(config.Endpoint); err != nil {
+		return err
+	}
+
+	if config.Bucket == "" {
+		return ErrMissingBucket
+	}
+	if config.AccessKey != "" && config.SecretKey == "" {
+		return ErrMissingSecret
+	}
+
+	return nil
+}
+
+func validateDevice(config Device) error {
+	if config.Profile != "" {
+		if _, err := device.Lookup(config.Profile); err != nil {
+			return err
+		}
+	}
+	if config.Refresh != "" {
+		return device.ValidateRefresh(config.Refresh)
+	}
+
+	return nil
+}
+
+func validateSFTP(config SFTP) error {
+	if config.Host == "" {
+		return ErrMissingHost
+	}
+	if config.User == "" {
+		return ErrMissingUser
+	}
+
+	return nil
+}
+
+// Load parses and validates the configuration file at path
+func Load(path string) (config Config, err error) {
+	if err := parseConfig(path, &config); err != nil {
 		return Config{}, err
 	}
 
@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+
+	"kloud/pkg/device"
 )
 
 func TestParseConfig(t *testing.T) {
@@ -42,6 +44,78 @@ share: XXXX`
 
 		equal(config.Server, "https://cloud.domain.com")
 		equal(config.ShareID, "XXXX")
+		equal(config.Traversal, "")
+	})
+
+	t.Run("Valid YAML with traversal", func(t *testing.T) {
+		rawYaml := `server: https://cloud.domain.com
+share: XXXX
+traversal: walk`
+
+		var config Config
+		if err := runParseConfig(rawYaml, &config); err != nil {
+			t.Error(err)
+		}
+
+		equal(config.Traversal, "walk")
+	})
+
+	t.Run("Valid YAML with password", func(t *testing.T) {
+		rawYaml := `server: https://cloud.domain.com
+share: XXXX
+password: "s3cr#t: yes"`
+
+		var config Config
+		if err := runParseConfig(rawYaml, &config); err != nil {
+			t.Error(err)
+		}
+
+		equal(config.Password, "s3cr#t: yes")
+		equal(config.Redacted().Password, redacted)
+		equal(config.Password, "s3cr#t: yes")
+	})
+
+	t.Run("Valid YAML with WebDAV backend", func(t *testing.T) {
+		rawYaml := `type: webdav
+webdav:
+  url: https://dav.domain.com/books
+  auth: bearer
+  token: t0k3n`
+
+		var config Config
+		if err := runParseConfig(rawYaml, &config); err != nil {
+			t.Error(err)
+		}
+
+		equal(config.Type, TypeWebDAV)
+		equal(config.WebDAV.URL, "https://dav.domain.com/books")
+		equal(config.WebDAV.Auth, AuthBearer)
+		equal(config.WebDAV.Token, "t0k3n")
+		equal(config.Redacted().WebDAV.Token, redacted)
+	})
+
+	t.Run("Valid YAML with S3 backend", func(t *testing.T) {
+		rawYaml := `type: s3
+s3:
+  endpoint: https://minio.domain.com
+  bucket: library
+  prefix: books
+  access_key: kobo
+  secret_key: s3cr3t
+  virtual_host: true`
+
+		var config Config
+		if err := runParseConfig(rawYaml, &config); err != nil {
+			t.Error(err)
+		}
+
+		equal(config.Type, TypeS3)
+		equal(config.S3.Bucket, "library")
+		equal(config.S3.Prefix, "books")
+		equal(config.S3.AccessKey, "kobo")
+		equal(config.S3.VirtualHost, true)
+		equal(config.Redacted().S3.SecretKey, redacted)
+		equal(config.Redacted().S3.AccessKey, "kobo")
 	})
 
 	t.Run("Invalid YAML", func(t *testing.T) {
@@ -68,7 +142,7 @@ func TestValidateConfig(t *testing.T) {
 	defer tmpFile.Close()
 	defer os.Remove(tmpFile.Name())
 
-	config := Config{"https://cloud.domain.com", "XXX"}
+	config := Config{Server: "https://cloud.domain.com", ShareID: "XXX"}
 
 	equal := func(err, target error) {
 		if errors.Is(err, target) == false {
@@ -80,4 +154,112 @@ func TestValidateConfig(t *testing.T) {
 
 	config.Server = "cloud.domain.com"
 	equal(validateConfig(config), ErrMissingScheme)
+
+	config = Config{Server: "https://cloud.domain.com"}
+	equal(validateConfig(config), ErrMissingShare)
+
+	config = Config{Mode: ModeAccount, Server: "https://cloud.domain.com", User: "alice", Password: "xxxxx-xxxxx"}
+	equal(validateConfig(config), nil)
+
+	config.User = ""
+	equal(validateConfig(config), ErrMissingUser)
+
+	config = Config{Mode: ModeAccount, Server: "https://cloud.domain.com", User: "alice"}
+	equal(validateConfig(config), ErrMissingPassword)
+
+	config.Mode = "ftp"
+	equal(validateConfig(config), ErrUnknownMode)
+
+	config = Config{Type: "gopher", Server: "https://cloud.domain.com", ShareID: "XXX"}
+	equal(validateConfig(config), ErrUnknownType)
+
+	config = Config{Type: TypeWebDAV, WebDAV: WebDAV{URL: "https://dav.domain.com/books"}}
+	equal(validateConfig(config), nil)
+
+	config.WebDAV.URL = "dav.domain.com/books"
+	equal(validateConfig(config), ErrMissingScheme)
+
+	config.WebDAV = WebDAV{URL: "https://dav.domain.com/books", Auth: AuthBasic}
+	equal(validateConfig(config), ErrMissingUser)
+
+	config.WebDAV.Auth = AuthBearer
+	equal(validateConfig(config), ErrMissingToken)
+
+	config.WebDAV.Auth = "digest"
+	equal(validateConfig(config), ErrUnknownAuth)
+
+	config = Config{Type: TypeOPDS, OPDS: OPDS{URL: "https://books.domain.com/opds"}}
+	equal(validateConfig(config), nil)
+
+	config.OPDS.URL = "books.domain.com/opds"
+	equal(validateConfig(config), ErrMissingScheme)
+
+	config = Config{Type: TypeCalibre, Calibre: Calibre{URL: "https://calibre.domain.com", Tag: "kobo"}}
+	equal(validateConfig(config), nil)
+
+	config.Calibre.URL = "calibre.domain.com"
+	equal(validateConfig(config), ErrMissingScheme)
+
+	config = Config{Type: TypeS3, S3: S3{Endpoint: "https://minio.domain.com", Bucket: "books"}}
+	equal(validateConfig(config), nil)
+
+	config.S3.AccessKey = "kobo"
+	equal(validateConfig(config), ErrMissingSecret)
+
+	config.S3.SecretKey = "secret"
+	equal(validateConfig(config), nil)
+
+	config.S3.Bucket = ""
+	equal(validateConfig(config), ErrMissingBucket)
+
+	config.S3.Endpoint = "minio.domain.com"
+	equal(validateConfig(config), ErrMissingScheme)
+
+	config = Config{Type: TypeSFTP, SFTP: SFTP{Host: "nas.local", User: "kobo"}}
+	equal(validateConfig(config), nil)
+
+	config.SFTP.User = ""
+	equal(validateConfig(config), ErrMissingUser)
+
+	config.SFTP.Host = ""
+	equal(validateConfig(config), ErrMissingHost)
+
+	config = Config{Type: TypeAutoindex, Autoindex: Autoindex{URL: "https://files.domain.com/books/"}}
+	equal(validateConfig(config), nil)
+
+	config.Autoindex.URL = "files.domain.com/books/"
+	equal(validateConfig(config), ErrMissingScheme)
+
+	config = Config{Server: "https://cloud.domain.com", ShareID: "XXX", Device: Device{Profile: device.NameKoboSD, Refresh: device.RefreshNone}}
+	equal(validateConfig(config), nil)
+
+	config.Device.Profile = "kindle"
+	equal(validateConfig(config), device.ErrUnknownProfile)
+
+	config.Device = Device{Refresh: "reboot"}
+	equal(validateConfig(config), device.ErrUnknownRefresh)
+
+	config = Config{Server: "https://cloud.domain.com", ShareID: "XXX", Nickel: Nickel{CollectionDepth: -1}}
+	equal(validateConfig(config), ErrNegativeDepth)
+
+	config = Config{Server: "https://cloud.domain.com", ShareID: "XXX", Annotations: Annotations{Folder: "Highlights", Format: FormatJSON}}
+	equal(validateConfig(config), nil)
+
+	config.Annotations.Format = "pdf"
+	equal(validateConfig(config), ErrUnknownFormat)
+
+	config = Config{Server: "https://cloud.domain.com", ShareID: "XXX", Progress: Progress{Folder: "Progress", Name: "kobo-libra", Import: true}}
+	equal(validateConfig(config), nil)
+
+	config.Progress.Name = "kobo/libra"
+	equal(validateConfig(config), ErrInvalidName)
+
+	config = Config{Server: "https://cloud.domain.com", ShareID: "XXX", Reading: Reading{Protect: ProtectDelay, RecentDays: 14, DelayDays: 30}}
+	equal(validateConfig(config), nil)
+
+	config.Reading.Protect = "hide"
+	equal(validateConfig(config), ErrUnknownProtect)
+
+	config.Reading = Reading{Protect: ProtectKeep, RecentDays: -1}
+	equal(validateConfig(config), ErrNegativeDays)
 }
@@ -0,0 +1,172 @@
+package device
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Methods making the reader notice the books of the sync directory
+const (
+	// RefreshSDMount mounts the sync directory on the external SD card and makes Nickel rescan the card
+	RefreshSDMount = "sd-mount"
+	// RefreshSDRescan makes Nickel rescan the external SD card holding the sync directory
+	RefreshSDRescan = "sd-rescan"
+	// RefreshFlush writes the synced files to the storage, so a device mounted over USB can be unplugged.
+	// Nickel rescans its library once unplugged.
+	RefreshFlush = "flush"
+	// RefreshNone leaves the reader to notice the books by itself
+	RefreshNone = "none"
+)
+
+// Mechanisms starting a sync
+const (
+	// TriggerUdev starts a sync from a udev rule when a network interface comes up
+	TriggerUdev = "udev"
+	// TriggerManual leaves it to the user to run kloud
+	TriggerManual = "manual"
+)
+
+// Names of the profiles shipped with kloud
+const (
+	NameKobo    = "kobo"
+	NameKoboSD  = "kobo-sd"
+	NameDesktop = "desktop"
+)
+
+// Mount points of the Kobo storages
+const (
+	KoboMountPoint   = "/mnt/onboard"
+	KoboSDMountPoint = "/mnt/sd"
+)
+
+// nickelDatabase is where Nickel keeps its library, on the internal storage
+const nickelDatabase = ".kobo/KoboReader.sqlite"
+
+// Errors returned when resolving a profile
+var (
+	ErrUnknownProfile = errors.New("unknown device profile, expected kobo, kobo-sd or desktop")
+	ErrUnknownRefresh = errors.New("unknown library refresh, expected sd-mount, sd-rescan, flush or none")
+)
+
+// Profile describes where kloud keeps the library and its own files on a device, and how the device
+// notices the books and starts syncs
+type Profile struct {
+	Name        string
+	MountPoint  string
+	SyncDir     string
+	InternalDir string
+	Refresh     string
+	Trigger     string
+
+	// Database is the path of the database of Nickel, empty on devices without Nickel
+	Database string
+	// NickelRoot is where Nickel sees MountPoint
+	NickelRoot string
+}
+
+// newProfile creates a profile keeping the library and the files of kloud at the root of a mount point
+func newProfile(name, mountPoint, refresh, trigger string) Profile {
+	return Profile{
+		Name:        name,
+		MountPoint:  mountPoint,
+		SyncDir:     filepath.Join(mountPoint, "KloudSync"),
+		InternalDir: filepath.Join(mountPoint, ".kloud"),
+		Refresh:     refresh,
+		Trigger:     trigger,
+	}
+}
+
+// Kobo is the internal storage of a Kobo. Nickel only rescans the SD card, so the sync directory is mounted on it.
+func Kobo() Profile {
+	profile := newProfile(NameKobo, KoboMountPoint, RefreshSDMount, TriggerUdev)
+	profile.Database = path.Join(KoboMountPoint, nickelDatabase)
+	profile.NickelRoot = KoboMountPoint
+	return profile
+}
+
+// KoboSD is the external SD card of a Kobo
+func KoboSD() Profile {
+	profile := newProfile(NameKoboSD, KoboSDMountPoint, RefreshSDRescan, TriggerUdev)
+	profile.Database = path.Join(KoboMountPoint, nickelDatabase)
+	profile.NickelRoot = KoboSDMountPoint
+	return profile
+}
+
+// Desktop is a computer, keeping the library in the home directory of the user
+func Desktop() Profile {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return newProfile(NameDesktop, home, RefreshNone, TriggerManual)
+}
+
+// Lookup returns the profile shipped with kloud with the given name
+func Lookup(name string) (Profile, error) {
+	switch name {
+	case NameKobo:
+		return Kobo(), nil
+	case NameKoboSD:
+		return KoboSD(), nil
+	case NameDesktop:
+		return Desktop(), nil
+	default:
+		return Profile{}, ErrUnknownProfile
+	}
+}
+
+// NickelPaths returns the paths Nickel may know a file of the device by, none on devices without Nickel.
+// Files of the internal storage are also seen on the SD card, where the sd-mount refresh mounts the sync directory.
+func (p Profile) NickelPaths(fullPath string) []string {
+	if p.NickelRoot == "" {
+		return nil
+	}
+
+	rel, err := filepath.Rel(p.MountPoint, fullPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil
+	}
+	paths := []string{path.Join(p.NickelRoot, filepath.ToSlash(rel))}
+
+	if p.NickelRoot == KoboMountPoint {
+		if rel, err := filepath.Rel(p.SyncDir, fullPath); err == nil && strings.HasPrefix(rel, "..") == false {
+			paths = append(paths, path.Join(KoboSDMountPoint, filepath.Base(p.SyncDir), filepath.ToSlash(rel)))
+		}
+	}
+	return paths
+}
+
+// ValidateRefresh checks that a library refresh method is known
+func ValidateRefresh(refresh string) error {
+	switch refresh {
+	case RefreshSDMount, RefreshSDRescan, RefreshFlush, RefreshNone:
+		return nil
+	default:
+		return ErrUnknownRefresh
+	}
+}
+
+// Detect guesses the profile of the device kloud runs on
+func Detect() Profile {
+	executable, _ := os.Executable()
+	_, err := os.Stat(filepath.Join(KoboMountPoint, ".kobo"))
+	return detect(executable, err == nil)
+}
+
+// detect picks the Kobo profile whose internal directory holds the executable, as installed by the bootstrapper,
+// then the internal storage on a Kobo, and the desktop profile anywhere else
+func detect(executable string, onKobo bool) Profile {
+	for _, profile := range []Profile{Kobo(), KoboSD()} {
+		if executable != "" && filepath.Dir(executable) == profile.InternalDir {
+			return profile
+		}
+	}
+
+	if onKobo {
+		return Kobo()
+	}
+	return Desktop()
+}
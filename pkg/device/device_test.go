@@ -0,0 +1,116 @@
+package device
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{NameKobo, NameKoboSD, NameDesktop} {
+		profile, err := Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if profile.Name != name || profile.SyncDir == "" || profile.InternalDir == "" {
+			t.Errorf("unexpected profile %+v", profile)
+		}
+	}
+
+	sd, _ := Lookup(NameKoboSD)
+	if sd.SyncDir != "/mnt/sd/KloudSync" || sd.InternalDir != "/mnt/sd/.kloud" || sd.Refresh != RefreshSDRescan {
+		t.Errorf("unexpected SD profile %+v", sd)
+	}
+
+	if _, err := Lookup("kindle"); errors.Is(err, ErrUnknownProfile) == false {
+		t.Errorf("expected ErrUnknownProfile, got %v", err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		executable string
+		onKobo     bool
+		expected   string
+	}{
+		{"/mnt/onboard/.kloud/kloud", true, NameKobo},
+		{"/mnt/sd/.kloud/kloud", true, NameKoboSD},
+		{"/tmp/kloud", true, NameKobo},
+		{"/usr/local/bin/kloud", false, NameDesktop},
+		{"", false, NameDesktop},
+	}
+
+	for _, test := range tests {
+		if profile := detect(test.executable, test.onKobo); profile.Name != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.executable, test.expected, profile.Name)
+		}
+	}
+}
+
+func TestFindKobos(t *testing.T) {
+	dir := t.TempDir()
+	for _, mountPoint := range []string{"alice/KOBOeReader", "alice/USB"} {
+		os.MkdirAll(filepath.Join(dir, mountPoint), 0700)
+	}
+	os.MkdirAll(filepath.Join(dir, "alice/KOBOeReader/.kobo"), 0700)
+	os.WriteFile(filepath.Join(dir, "alice/KOBOeReader/.kobo/version"), []byte("N0000000000,4.38.21908\n"), 0600)
+
+	mountPoints, err := findKobos([]string{filepath.Join(dir, "*"), filepath.Join(dir, "*", "*")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kobo := filepath.Join(dir, "alice/KOBOeReader")
+	if len(mountPoints) != 1 || mountPoints[0] != kobo {
+		t.Fatalf("unexpected mount points %v", mountPoints)
+	}
+
+	profile, err := KoboUSB(kobo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.SyncDir != filepath.Join(kobo, "KloudSync") || profile.InternalDir != filepath.Join(kobo, ".kloud") || profile.Refresh != RefreshFlush {
+		t.Errorf("unexpected profile %+v", profile)
+	}
+	if version, _ := Version(kobo); version != "N0000000000,4.38.21908" {
+		t.Errorf("unexpected version %s", version)
+	}
+	if serial, _ := Serial(kobo); serial != "N0000000000" {
+		t.Errorf("unexpected serial %s", serial)
+	}
+
+	if _, err := KoboUSB(filepath.Join(dir, "alice/USB")); errors.Is(err, ErrNotKobo) == false {
+		t.Errorf("expected ErrNotKobo, got %v", err)
+	}
+}
+
+func TestRebase(t *testing.T) {
+	if path, err := Rebase("/mnt/onboard/Books", "/media/alice/KOBOeReader"); err != nil || path != "/media/alice/KOBOeReader/Books" {
+		t.Errorf("unexpected path %s (%v)", path, err)
+	}
+	if _, err := Rebase("/mnt/sd/Books", "/media/alice/KOBOeReader"); errors.Is(err, ErrNotOnStorage) == false {
+		t.Errorf("expected ErrNotOnStorage, got %v", err)
+	}
+}
+
+func TestNickelPaths(t *testing.T) {
+	expected := []string{"/mnt/onboard/KloudSync/Herbert/Dune.epub", "/mnt/sd/KloudSync/Herbert/Dune.epub"}
+	paths := Kobo().NickelPaths("/mnt/onboard/KloudSync/Herbert/Dune.epub")
+	if len(paths) != len(expected) || paths[0] != expected[0] || paths[1] != expected[1] {
+		t.Errorf("unexpected paths %v", paths)
+	}
+
+	usb := newProfile(NameKoboUSB, "/media/alice/KOBOeReader", RefreshFlush, TriggerManual)
+	usb.NickelRoot = KoboMountPoint
+	paths = usb.NickelPaths("/media/alice/KOBOeReader/KloudSync/Herbert/Dune.epub")
+	if len(paths) != len(expected) || paths[0] != expected[0] || paths[1] != expected[1] {
+		t.Errorf("unexpected paths %v", paths)
+	}
+
+	if paths := KoboSD().NickelPaths("/mnt/sd/KloudSync/Dune.epub"); len(paths) != 1 || paths[0] != "/mnt/sd/KloudSync/Dune.epub" {
+		t.Errorf("unexpected paths %v", paths)
+	}
+	if paths := Desktop().NickelPaths("/home/alice/KloudSync/Dune.epub"); len(paths) != 0 {
+		t.Errorf("unexpected paths %v", paths)
+	}
+}
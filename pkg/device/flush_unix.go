@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package device
+
+import "syscall"
+
+// flush writes the data cached by the kernel to the storages
+func flush() error {
+	syscall.Sync()
+	return nil
+}
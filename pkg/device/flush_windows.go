@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package device
+
+// flush does nothing on Windows, where the synced files are flushed one by one as they are written
+func flush() error {
+	return nil
+}
@@ -0,0 +1,95 @@
+package device
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// nickelHardwareStatus is the pipe Nickel reads hardware events from
+	nickelHardwareStatus = "/tmp/nickel-hardware-status"
+	// sdAddEvent tells Nickel an SD card was inserted, which makes it rescan the card
+	sdAddEvent = "sd add /dev/mmcblk1p1\n"
+	// procMounts lists the mounted filesystems
+	procMounts = "/proc/mounts"
+)
+
+// RefreshLibrary makes the reader notice the books of the sync directory with the refresh method of the profile,
+// when changed reports that books were added or removed. The bind mount of the sd-mount method does not survive a
+// reboot, so it is restored and rescanned whatever changed, and flushes always run for devices about to be unplugged.
+// Trick from https://github.com/wernerb/kobo-wget-sync/blob/master/src/usr/local/wget-sync/refresh_library.sh
+func (p Profile) RefreshLibrary(changed bool) error {
+	switch p.Refresh {
+	case RefreshNone:
+		return nil
+	case RefreshSDMount:
+		mounted, err := p.mountSD()
+		if err != nil {
+			return err
+		}
+		if changed || mounted {
+			return rescanSD()
+		}
+		return nil
+	case RefreshSDRescan:
+		if changed {
+			return rescanSD()
+		}
+		return nil
+	case RefreshFlush:
+		return flush()
+	default:
+		return ErrUnknownRefresh
+	}
+}
+
+// mountSD bind mounts the sync directory on the SD card mount point, and reports whether it was not mounted yet
+func (p Profile) mountSD() (bool, error) {
+	sdSyncDir := filepath.Join(KoboSDMountPoint, filepath.Base(p.SyncDir))
+	if err := os.MkdirAll(sdSyncDir, 0755); err != nil {
+		return false, err
+	}
+
+	mounted, err := isMounted(sdSyncDir)
+	if err != nil || mounted {
+		return false, err
+	}
+	if out, err := exec.Command("mount", "-o", "bind", p.SyncDir, sdSyncDir).CombinedOutput(); err != nil {
+		return false, fmt.Errorf("cannot mount %s on %s: %w: %s", p.SyncDir, sdSyncDir, err, strings.TrimSpace(string(out)))
+	}
+	return true, nil
+}
+
+// rescanSD sends Nickel the event of an inserted SD card
+func rescanSD() error {
+	file, err := os.OpenFile(nickelHardwareStatus, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(sdAddEvent)
+	return err
+}
+
+// isMounted reports whether a filesystem is mounted on dir
+func isMounted(dir string) (bool, error) {
+	file, err := os.Open(procMounts)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[1] == dir {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,107 @@
+package device
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NameKoboUSB is the profile of a Kobo mounted on a computer over USB
+const NameKoboUSB = "kobo-usb"
+
+// versionFile identifies the root of a Kobo storage
+const versionFile = ".kobo/version"
+
+// mountPatterns are where desktops mount removable drives
+var mountPatterns = []string{"/media/*", "/media/*/*", "/run/media/*/*", "/mnt/*", "/Volumes/*"}
+
+// Errors returned when looking for a Kobo mounted over USB
+var (
+	ErrNotKobo      = errors.New("not the root of a Kobo, .kobo/version is missing")
+	ErrNoKobo       = errors.New("no mounted Kobo found")
+	ErrSeveralKobos = errors.New("several mounted Kobos found, pass the mount point of one")
+	ErrNotOnStorage = errors.New("path is not on the internal storage of the Kobo")
+)
+
+// KoboUSB is a Kobo whose internal storage is mounted on a computer at mountPoint. It shares the library and the
+// state of kloud running on the device, and the files are flushed to the device once synced so it can be unplugged.
+func KoboUSB(mountPoint string) (Profile, error) {
+	if _, err := os.Stat(filepath.Join(mountPoint, versionFile)); err != nil {
+		if os.IsNotExist(err) {
+			return Profile{}, ErrNotKobo
+		}
+		return Profile{}, err
+	}
+
+	profile := newProfile(NameKoboUSB, mountPoint, RefreshFlush, TriggerManual)
+	profile.Database = filepath.Join(mountPoint, nickelDatabase)
+	profile.NickelRoot = KoboMountPoint
+	return profile, nil
+}
+
+// FindKobo returns the mount point of the only Kobo mounted on the computer
+func FindKobo() (string, error) {
+	mountPoints, err := findKobos(mountPatterns)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(mountPoints) {
+	case 0:
+		return "", ErrNoKobo
+	case 1:
+		return mountPoints[0], nil
+	default:
+		return "", ErrSeveralKobos
+	}
+}
+
+// findKobos lists the directories matching the patterns that hold a .kobo/version file
+func findKobos(patterns []string) ([]string, error) {
+	found := map[string]bool{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(pattern, versionFile))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			found[filepath.Dir(filepath.Dir(match))] = true
+		}
+	}
+
+	mountPoints := make([]string, 0, len(found))
+	for mountPoint := range found {
+		mountPoints = append(mountPoints, mountPoint)
+	}
+	sort.Strings(mountPoints)
+	return mountPoints, nil
+}
+
+// Version returns the content of the version file of a Kobo, holding its serial number and firmware version
+func Version(mountPoint string) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(mountPoint, versionFile))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// Serial returns the serial number of a Kobo, from its version file
+func Serial(mountPoint string) (string, error) {
+	version, err := Version(mountPoint)
+	if err != nil {
+		return "", err
+	}
+	return strings.Split(version, ",")[0], nil
+}
+
+// Rebase moves a path of the Kobo internal storage under the mount point of the Kobo on a computer
+func Rebase(path, mountPoint string) (string, error) {
+	if path != KoboMountPoint && strings.HasPrefix(path, KoboMountPoint+"/") == false {
+		return "", ErrNotOnStorage
+	}
+	return filepath.Join(mountPoint, strings.TrimPrefix(path, KoboMountPoint)), nil
+}
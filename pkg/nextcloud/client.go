@@ -1,39 +1,148 @@
+// Package nextcloud syncs a library from a NextCloud public share or user folder, on top of the WebDAV client.
 package nextcloud
 
 import (
-	"crypto/tls"
-	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"kloud/pkg/backend"
+	"kloud/pkg/webdav"
+)
+
+// Client is a NextCloud client for Kloud.
+// It either reads a public share or a folder of a user account, through their respective DAV roots.
+type Client = webdav.Client
+
+// File is a NextCloud remote file
+type File = webdav.File
+
+// Traversal is the strategy used to list the remote share
+type Traversal = webdav.Traversal
+
+// StatusError is returned when the server answers with an unexpected HTTP status code
+type StatusError = webdav.StatusError
+
+// Traversal modes supported by the client
+const (
+	TraversalAuto     = webdav.TraversalAuto
+	TraversalInfinity = webdav.TraversalInfinity
+	TraversalWalk     = webdav.TraversalWalk
+)
+
+// Errors of the WebDAV client, under the names the NextCloud client always used
+var (
+	ErrUnableToAppendCerts = webdav.ErrUnableToAppendCerts
+	ErrUnknownTraversal    = webdav.ErrUnknownTraversal
+	ErrWrongPassword       = backend.ErrUnauthorized
+	ErrHrefOutsideRoot     = webdav.ErrHrefOutsideRoot
 )
 
-// Errors returned by the NewClient function
+// Errors returned when looking up the user of an account
 var (
-	ErrUnableToAppendCerts = errors.New("unable to append certificates to pool")
+	ErrNoUserID = errors.New("the server did not tell the ID of the user")
+)
+
+// DAV roots of NextCloud, for servers hosted at the root of their domain
+const (
+	// publicRoot is the DAV root of a public share
+	publicRoot = "/public.php/webdav"
+	// filesRoot is the parent of the DAV roots of user accounts, followed by the user ID
+	filesRoot = "/remote.php/dav/files"
+	// userEndpoint is the OCS endpoint describing the authenticated user
+	userEndpoint = "/ocs/v1.php/cloud/user"
 )
 
-// Client is a NextCloud client for Kloud and wraps http.Client
-type Client struct {
-	http    http.Client
-	server  string
-	shareID string
+// Library is a NextCloud folder as a backend of the sync engine.
+// NextCloud propagates the ETag of files to the folders containing them, so the ETag of the root folder
+// changes whenever anything changes in the library.
+type Library struct {
+	*Client
 }
 
-// NewClient creates a new NextCloud client with the configured TLS settings
-func NewClient(cacert []byte, server, shareID string) (Client, error) {
-	caCertPool := x509.NewCertPool()
-	ok := caCertPool.AppendCertsFromPEM(cacert)
-	if ok == false {
-		return Client{}, ErrUnableToAppendCerts
+// Library can tell whether anything changed with a single request
+var _ backend.Versioner = Library{}
+
+// Version returns the ETag of the root folder of the library
+func (l Library) Version() (string, error) {
+	return l.RootETag()
+}
+
+// NewClient creates a new NextCloud client for a public share with the configured TLS settings
+func NewClient(cacert []byte, server, shareID, password string, traversal Traversal) (Client, error) {
+	return newClient(cacert, server, publicRoot, shareID, password, traversal)
+}
+
+// NewAccountClient creates a new NextCloud client for a folder of a user account, authenticated with
+// an app password, with the configured TLS settings. The user logs in with their login name, and the server is
+// asked for their ID, which names their DAV root.
+func NewAccountClient(cacert []byte, server, user, appPassword, folder string, traversal Traversal) (Client, error) {
+	id, err := userID(cacert, server, user, appPassword)
+	if err != nil {
+		return Client{}, err
+	}
+
+	davRoot := path.Join(filesRoot, id, folder)
+	return newClient(cacert, server, davRoot, user, appPassword, traversal)
+}
+
+// userID returns the ID of the user logging in as user. It differs from the login name for users logging in with
+// their email address or through LDAP.
+func userID(cacert []byte, server, user, password string) (string, error) {
+	httpClient, err := backend.NewHTTPClient(cacert)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(server, "/")+userEndpoint+"?format=json", nil)
+	if err != nil {
+		return "", err
 	}
+	req.SetBasicAuth(user, password)
+	req.Header.Set("OCS-APIRequest", "true")
 
-	httpClient := http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs: caCertPool,
-			},
-		},
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
 	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return "", backend.ErrUnauthorized
+	default:
+		return "", backend.StatusError{StatusCode: resp.StatusCode}
+	}
+
+	// The OCS API v1 tells failures in the document, 997 meaning the request is not authenticated
+	var document struct {
+		OCS struct {
+			Meta struct {
+				StatusCode int `json:"statuscode"`
+			} `json:"meta"`
+			Data struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		} `json:"ocs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		return "", err
+	}
+	if document.OCS.Meta.StatusCode == 997 {
+		return "", backend.ErrUnauthorized
+	}
+	if document.OCS.Data.ID == "" {
+		return "", ErrNoUserID
+	}
+	return document.OCS.Data.ID, nil
+}
 
-	return Client{http: httpClient, server: server, shareID: shareID}, nil
+// newClient creates a WebDAV client for a DAV root of the server, which can be hosted under a sub-path
+func newClient(cacert []byte, server, davRoot, user, password string, traversal Traversal) (Client, error) {
+	baseURL := strings.TrimSuffix(server, "/") + (&url.URL{Path: davRoot}).EscapedPath()
+	return webdav.NewClient(cacert, baseURL, webdav.BasicAuth(user, password), traversal)
 }
@@ -0,0 +1,175 @@
+package nextcloud
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPassword(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if ok == false || user != "XXX" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, multistatus("", []string{"book.epub"}))
+	}))
+	defer srv.Close()
+	cacert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	t.Run("Valid password", func(t *testing.T) {
+		client, err := NewClient(cacert, srv.URL, "XXX", "secret", TraversalInfinity)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files, err := listFiles(&client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := files["book.epub"]; ok == false {
+			t.Errorf("expected book.epub in %v", files)
+		}
+	})
+
+	for _, password := range []string{"", "wrong"} {
+		t.Run(fmt.Sprintf("Invalid password %q", password), func(t *testing.T) {
+			client, err := NewClient(cacert, srv.URL, "XXX", password, TraversalAuto)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := listFiles(&client); err != ErrWrongPassword {
+				t.Errorf("expected %v, got %v", ErrWrongPassword, err)
+			}
+			if _, err := readFile(&client, "book.epub"); err != ErrWrongPassword {
+				t.Errorf("expected %v, got %v", ErrWrongPassword, err)
+			}
+		})
+	}
+}
+
+func TestAccountClient(t *testing.T) {
+	var paths []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if ok == false || user != "alice@domain.com" || password != "app-password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// Alice logs in with her email address, her files are under her user ID
+		if r.URL.Path == "/nextcloud/ocs/v1.php/cloud/user" {
+			if r.Header.Get("OCS-APIRequest") != "true" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"ocs":{"meta":{"status":"ok","statuscode":100},"data":{"id":"alice","display-name":"Alice","email":"alice@domain.com"}}}`)
+			return
+		}
+		paths = append(paths, r.URL.EscapedPath())
+
+		if r.Method == "GET" {
+			fmt.Fprint(w, "content")
+			return
+		}
+
+		// Answer like a server hosted under /nextcloud
+		root := "/nextcloud/remote.php/dav/files/alice/My%20Books/"
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:"><d:response><d:href>%s</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>%sbook.epub</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>7</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`, root, root)
+	}))
+	defer srv.Close()
+	cacert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	client, err := NewAccountClient(cacert, srv.URL+"/nextcloud/", "alice@domain.com", "app-password", "My Books", TraversalInfinity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := listFiles(&client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file, ok := files["book.epub"]; ok == false || file.Size != 7 {
+		t.Errorf("expected book.epub of 7 bytes in %v", files)
+	}
+
+	content, err := readFile(&client, "book.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "content" {
+		t.Errorf("expected content, got %s", content)
+	}
+
+	expected := []string{
+		"/nextcloud/remote.php/dav/files/alice/My%20Books/",
+		"/nextcloud/remote.php/dav/files/alice/My%20Books/book.epub",
+	}
+	if fmt.Sprint(paths) != fmt.Sprint(expected) {
+		t.Errorf("expected requests to %v, got %v", expected, paths)
+	}
+
+	if _, err := NewAccountClient(cacert, srv.URL+"/nextcloud/", "alice@domain.com", "wrong", "My Books", TraversalInfinity); err != ErrWrongPassword {
+		t.Errorf("expected %v, got %v", ErrWrongPassword, err)
+	}
+}
+
+func TestLibraryVersion(t *testing.T) {
+	etag := `"61a3cb17"`
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" || r.Header.Get("Depth") != "0" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:"><d:response><d:href>/public.php/webdav/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype><d:getetag>%s</d:getetag></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`, etag)
+	}))
+	defer srv.Close()
+	cacert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	client, err := NewClient(cacert, srv.URL, "XXX", "", TraversalAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := Library{Client: &client}.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != etag {
+		t.Errorf("expected version %s, got %s", etag, version)
+	}
+}
+
+// listFiles lists the remote collection by path
+func listFiles(client *Client) (map[string]File, error) {
+	files := map[string]File{}
+	err := client.ListRemoteFiles(func(file File) error {
+		files[file.Path] = file
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// readFile downloads the content of a file
+func readFile(client *Client, fileName string) ([]byte, error) {
+	body, err := client.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
@@ -0,0 +1,122 @@
+package nextcloud
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// userAgent is shown by NextCloud in the login page and in the list of app passwords
+const userAgent = "kloud"
+
+// Errors returned by the login flow
+var (
+	ErrLoginPending = errors.New("login has not been granted yet")
+	ErrLoginTimeout = errors.New("login was not granted in time")
+)
+
+// LoginFlow is a NextCloud Login Flow v2 started on a server, waiting for the user to grant access
+type LoginFlow struct {
+	// Login is the URL the user has to open in a browser to grant access
+	Login string
+
+	http     *http.Client
+	token    string
+	endpoint string
+}
+
+// Credentials are the app password credentials granted at the end of a login flow
+type Credentials struct {
+	Server      string `json:"server"`
+	LoginName   string `json:"loginName"`
+	AppPassword string `json:"appPassword"`
+}
+
+// StartLoginFlow initiates a Login Flow v2 on the server
+func StartLoginFlow(httpClient *http.Client, server string) (LoginFlow, error) {
+	req, err := http.NewRequest("POST", strings.TrimSuffix(server, "/")+"/index.php/login/v2", nil)
+	if err != nil {
+		return LoginFlow{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return LoginFlow{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return LoginFlow{}, StatusError{StatusCode: resp.StatusCode}
+	}
+
+	payload := struct {
+		Poll struct {
+			Token    string `json:"token"`
+			Endpoint string `json:"endpoint"`
+		} `json:"poll"`
+		Login string `json:"login"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return LoginFlow{}, err
+	}
+
+	return LoginFlow{
+		Login:    payload.Login,
+		http:     httpClient,
+		token:    payload.Poll.Token,
+		endpoint: payload.Poll.Endpoint,
+	}, nil
+}
+
+// Poll checks once whether the user granted access, returning ErrLoginPending if they did not yet
+func (f LoginFlow) Poll() (Credentials, error) {
+	form := url.Values{"token": {f.token}}
+	req, err := http.NewRequest("POST", f.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := f.http.Do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer resp.Body.Close()
+
+	// The server answers 404 until access is granted
+	if resp.StatusCode == http.StatusNotFound {
+		return Credentials{}, ErrLoginPending
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Credentials{}, StatusError{StatusCode: resp.StatusCode}
+	}
+
+	var credentials Credentials
+	if err := json.NewDecoder(resp.Body).Decode(&credentials); err != nil {
+		return Credentials{}, err
+	}
+
+	return credentials, nil
+}
+
+// Wait polls the server every interval until the user grants access, giving up after timeout
+func (f LoginFlow) Wait(interval, timeout time.Duration) (Credentials, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		credentials, err := f.Poll()
+		if errors.Is(err, ErrLoginPending) == false {
+			return credentials, err
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return Credentials{}, ErrLoginTimeout
+		}
+		time.Sleep(interval)
+	}
+}
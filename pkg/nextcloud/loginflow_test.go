@@ -0,0 +1,91 @@
+package nextcloud
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newLoginFlowServer(grantAfter int) *httptest.Server {
+	polls := 0
+
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+	mux.HandleFunc("/index.php/login/v2", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		fmt.Fprintf(w, `{"poll":{"token":"t0k3n","endpoint":"%s/login/v2/poll"},"login":"%s/login/v2/flow/abcd"}`, srv.URL, srv.URL)
+	})
+	mux.HandleFunc("/login/v2/poll", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("token") != "t0k3n" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		polls++
+		if grantAfter < 0 || polls < grantAfter {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"server":"%s","loginName":"alice","appPassword":"app-password"}`, srv.URL)
+	})
+
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+func TestLoginFlow(t *testing.T) {
+	t.Run("Granted", func(t *testing.T) {
+		srv := newLoginFlowServer(3)
+		defer srv.Close()
+
+		flow, err := StartLoginFlow(srv.Client(), srv.URL+"/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flow.Login != srv.URL+"/login/v2/flow/abcd" {
+			t.Errorf("unexpected login URL %s", flow.Login)
+		}
+
+		if _, err := flow.Poll(); err != ErrLoginPending {
+			t.Errorf("expected %v, got %v", ErrLoginPending, err)
+		}
+
+		credentials, err := flow.Wait(time.Millisecond, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := Credentials{Server: srv.URL, LoginName: "alice", AppPassword: "app-password"}
+		if credentials != expected {
+			t.Errorf("expected %+v, got %+v", expected, credentials)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		srv := newLoginFlowServer(-1)
+		defer srv.Close()
+
+		flow, err := StartLoginFlow(srv.Client(), srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := flow.Wait(time.Millisecond, 10*time.Millisecond); err != ErrLoginTimeout {
+			t.Errorf("expected %v, got %v", ErrLoginTimeout, err)
+		}
+	})
+
+	t.Run("Not a NextCloud server", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		defer srv.Close()
+
+		if _, err := StartLoginFlow(srv.Client(), srv.URL); err == nil {
+			t.Errorf("Expected an error but got nil")
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package nextcloud
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	var requests []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		request := fmt.Sprintf("%s %s %s %s", r.Method, r.URL.EscapedPath(), r.Header.Get("Destination"), body)
+		requests = append(requests, strings.TrimSpace(request))
+
+		switch {
+		case r.Method == "MKCOL" && r.URL.Path == "/public.php/webdav/Notes":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.Method == "MKCOL" || r.Method == "PUT" || r.Method == "MOVE":
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "DELETE" && r.URL.Path == "/public.php/webdav/missing.md":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+	cacert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	client, err := NewClient(cacert, srv.URL, "XXX", "", TraversalAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Upload("Notes/My Book/notes.md", strings.NewReader("# Notes")); err != nil {
+		t.Error(err)
+	}
+	if err := client.Move("Notes/My Book/notes.md", "Notes/notes.md"); err != nil {
+		t.Error(err)
+	}
+	if err := client.Delete("Notes/notes.md"); err != nil {
+		t.Error(err)
+	}
+	if err := client.Delete("missing.md"); err == nil {
+		t.Errorf("Expected an error but got nil")
+	}
+
+	expected := []string{
+		"MKCOL /public.php/webdav/Notes",
+		"MKCOL /public.php/webdav/Notes/My%20Book",
+		"PUT /public.php/webdav/Notes/My%20Book/notes.md  # Notes",
+		"MOVE /public.php/webdav/Notes/My%20Book/notes.md " + srv.URL + "/public.php/webdav/Notes/notes.md",
+		"DELETE /public.php/webdav/Notes/notes.md",
+		"DELETE /public.php/webdav/missing.md",
+	}
+	if strings.Join(requests, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected requests:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(requests, "\n"))
+	}
+}
@@ -0,0 +1,208 @@
+package nextcloud
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testTree maps a collection path to its children, collections ending with a slash
+var testTree = map[string][]string{
+	"":    {"a/", "root.epub"},
+	"a":   {"a/b/", "a/one.epub"},
+	"a/b": {"a/b/two.epub", "a/"},
+}
+
+// testTreeFlat is testTree as returned by a Depth: infinity PROPFIND on the root
+var testTreeFlat = []string{"a/", "a/b/", "a/b/two.epub", "a/one.epub", "root.epub"}
+
+func multistatus(dir string, children []string) string {
+	response := func(href string, collection bool) string {
+		resourceType := "<d:resourcetype/><d:getcontentlength>1</d:getcontentlength>"
+		if collection {
+			resourceType = "<d:resourcetype><d:collection/></d:resourcetype>"
+		}
+		href = "/public.php/webdav/" + (&url.URL{Path: href}).EscapedPath()
+		return fmt.Sprintf(`<d:response><d:href>%s</d:href><d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, href, resourceType)
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">`)
+	b.WriteString(response(dir+"/", true))
+	for _, child := range children {
+		b.WriteString(response(child, strings.HasSuffix(child, "/")))
+	}
+	b.WriteString(`</d:multistatus>`)
+	return b.String()
+}
+
+func newTestServer(allowInfinity bool) (*httptest.Server, []byte, *[]string) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dir := strings.Trim(strings.TrimPrefix(r.URL.Path, "/public.php/webdav"), "/")
+		depth := r.Header.Get("Depth")
+
+		mu.Lock()
+		requests = append(requests, depth+" "+dir)
+		mu.Unlock()
+
+		if depth == "infinity" {
+			if allowInfinity == false {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, multistatus(dir, testTreeFlat))
+			return
+		}
+
+		children, ok := testTree[dir]
+		if ok == false {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, multistatus(dir, children))
+	}))
+
+	cacert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	return srv, cacert, &requests
+}
+
+func TestListRemoteFiles(t *testing.T) {
+	expected := []string{"a/b/two.epub", "a/one.epub", "root.epub"}
+
+	check := func(t *testing.T, files map[string]File) {
+		var paths []string
+		for path := range files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		if strings.Join(paths, ",") != strings.Join(expected, ",") {
+			t.Errorf("expected %v, got %v", expected, paths)
+		}
+	}
+
+	t.Run("Walk", func(t *testing.T) {
+		srv, cacert, requests := newTestServer(true)
+		defer srv.Close()
+
+		client, err := NewClient(cacert, srv.URL, "XXX", "", TraversalWalk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files, err := listFiles(&client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, files)
+
+		// The loop from a/b to a must not be followed
+		if len(*requests) != 3 {
+			t.Errorf("expected 3 requests, got %v", *requests)
+		}
+	})
+
+	t.Run("Infinity", func(t *testing.T) {
+		srv, cacert, requests := newTestServer(true)
+		defer srv.Close()
+
+		client, err := NewClient(cacert, srv.URL, "XXX", "", TraversalAuto)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files, err := listFiles(&client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, files)
+
+		if len(*requests) != 1 {
+			t.Errorf("expected 1 request, got %v", *requests)
+		}
+	})
+
+	t.Run("Fallback to walk", func(t *testing.T) {
+		srv, cacert, requests := newTestServer(false)
+		defer srv.Close()
+
+		client, err := NewClient(cacert, srv.URL, "XXX", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files, err := listFiles(&client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, files)
+
+		if len(*requests) != 4 {
+			t.Errorf("expected 4 requests, got %v", *requests)
+		}
+	})
+
+	t.Run("Infinity refused", func(t *testing.T) {
+		srv, cacert, _ := newTestServer(false)
+		defer srv.Close()
+
+		client, err := NewClient(cacert, srv.URL, "XXX", "", TraversalInfinity)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := listFiles(&client); err == nil {
+			t.Errorf("Expected an error but got nil")
+		}
+	})
+
+	t.Run("Callback error stops the listing", func(t *testing.T) {
+		srv, cacert, _ := newTestServer(true)
+		defer srv.Close()
+
+		client, err := NewClient(cacert, srv.URL, "XXX", "", TraversalWalk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stop := errors.New("stop")
+		calls := 0
+		err = client.ListRemoteFiles(func(file File) error {
+			calls++
+			return stop
+		})
+		if err != stop {
+			t.Errorf("expected %v, got %v", stop, err)
+		}
+		if calls != 1 {
+			t.Errorf("expected 1 call, got %d", calls)
+		}
+	})
+
+	t.Run("Unknown traversal", func(t *testing.T) {
+		if _, err := NewClient(testCACert(), "https://cloud.domain.com", "XXX", "", "sideways"); err != ErrUnknownTraversal {
+			t.Errorf("expected %v, got %v", ErrUnknownTraversal, err)
+		}
+	})
+}
+
+func testCACert() []byte {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+}
@@ -0,0 +1,100 @@
+package nickel
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Types of annotations
+const (
+	AnnotationHighlight = "highlight"
+	AnnotationNote      = "note"
+	AnnotationBookmark  = "dogear"
+)
+
+// Annotation is a highlight, a note or a bookmark of a book
+type Annotation struct {
+	ID       string  `json:"id"`
+	Type     string  `json:"type"`
+	Chapter  string  `json:"chapter,omitempty"`
+	Progress float64 `json:"chapter_progress"`
+	Text     string  `json:"text,omitempty"`
+	Note     string  `json:"note,omitempty"`
+	Created  string  `json:"created,omitempty"`
+	Modified string  `json:"modified,omitempty"`
+}
+
+// BookAnnotations are the annotations of a book, in reading order
+type BookAnnotations struct {
+	Title       string       `json:"title"`
+	Author      string       `json:"author,omitempty"`
+	Annotations []Annotation `json:"annotations"`
+}
+
+// annotationsQuery lists the annotations of the books under a root, with the chapter they are in
+const annotationsQuery = `SELECT b.BookmarkID, b.VolumeID, b.Type, b.Text, b.Annotation, b.ChapterProgress, b.DateCreated, b.DateModified,
+		book.Title, book.Attribution, chapter.Title
+	FROM Bookmark b
+	LEFT JOIN content book ON book.ContentID = b.VolumeID
+	LEFT JOIN content chapter ON chapter.ContentID = b.ContentID
+	WHERE b.Hidden != 'true' AND substr(b.VolumeID, 1, ?) = ?
+	ORDER BY b.VolumeID, chapter.VolumeIndex, b.ChapterProgress, b.DateCreated`
+
+// Annotations returns the annotations of the books under roots, by the path of the books as seen by Nickel
+func (d *DB) Annotations(roots []string) (map[string]BookAnnotations, error) {
+	books := map[string]BookAnnotations{}
+
+	for _, root := range roots {
+		prefix := ContentID(root) + "/"
+		rows, err := d.db.Query(annotationsQuery, len(prefix), prefix)
+		if err != nil {
+			return nil, lockError(err)
+		}
+
+		for rows.Next() {
+			var (
+				a                                   Annotation
+				volumeID                            string
+				kind, text, note, created, modified sql.NullString
+				title, author, chapter              sql.NullString
+			)
+			err := rows.Scan(&a.ID, &volumeID, &kind, &text, &note, &a.Progress, &created, &modified, &title, &author, &chapter)
+			if err != nil {
+				rows.Close()
+				return nil, lockError(err)
+			}
+
+			a.Type, a.Text, a.Note, a.Chapter = kind.String, text.String, note.String, chapter.String
+			a.Created, a.Modified = created.String, modified.String
+			if a.Type == "" {
+				a.Type = annotationType(a)
+			}
+
+			path := strings.TrimPrefix(volumeID, "file://")
+			book := books[path]
+			book.Title, book.Author = title.String, author.String
+			book.Annotations = append(book.Annotations, a)
+			books[path] = book
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, lockError(err)
+		}
+	}
+
+	return books, nil
+}
+
+// annotationType guesses the type of annotations written by firmwares that did not record it
+func annotationType(a Annotation) string {
+	switch {
+	case a.Note != "":
+		return AnnotationNote
+	case a.Text != "":
+		return AnnotationHighlight
+	default:
+		return AnnotationBookmark
+	}
+}
@@ -0,0 +1,137 @@
+package nickel
+
+import (
+	"sort"
+	"time"
+)
+
+// ContentID returns the ID Nickel gives to the book at path, as seen by Nickel
+func ContentID(path string) string {
+	return "file://" + path
+}
+
+// RemoveBooks removes the books at the given paths, as seen by Nickel, from its library in a single transaction.
+// Their chapters go with them and they are marked deleted in the collections, as Nickel does.
+// It returns the number of books removed, paths Nickel does not know being ignored.
+func (d *DB) RemoveBooks(paths []string) (int, error) {
+	removed := 0
+	modified := time.Now().UTC().Format(timeFormat)
+
+	err := d.update(func(t tx) error {
+		removed = 0
+		for _, path := range paths {
+			n, err := removeBook(t, ContentID(path), modified)
+			if err != nil {
+				return err
+			}
+			removed += int(n)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// removeBook removes a book and its chapters, and marks it deleted in the collections. It returns the number of
+// books removed.
+func removeBook(t tx, contentID, modified string) (int64, error) {
+	n, err := t.exec("DELETE FROM content WHERE ContentID = ?", contentID)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := t.exec("DELETE FROM content WHERE BookID = ?", contentID); err != nil {
+		return 0, err
+	}
+	if _, err := t.exec("DELETE FROM volume_shortcovers WHERE volumeId = ?", contentID); err != nil {
+		return 0, err
+	}
+	if _, err := t.exec("UPDATE ShelfContent SET _IsDeleted = 'true', _IsSynced = 'false', DateModified = ? WHERE ContentId = ?", modified, contentID); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// MoveBooks updates the library of Nickel for books moved on the device, mapping their previous path to their new
+// one as seen by Nickel, in a single transaction. Their chapters, bookmarks, progress and collections follow them.
+// When several books are moved to the same path, such as the two paths of a book of the sync directory under an
+// sd-mount refresh, the most recently read one is moved and the others are removed.
+// It returns the number of books moved, paths Nickel does not know being ignored.
+func (d *DB) MoveBooks(moves map[string]string) (int, error) {
+	moved := 0
+	modified := time.Now().UTC().Format(timeFormat)
+
+	err := d.update(func(t tx) error {
+		moved = 0
+
+		// Move the most recently read books first, Nickel writes dates in a single format so they compare as strings
+		lastRead := map[string]string{}
+		var froms []string
+		for from := range moves {
+			values, err := t.column("SELECT IFNULL(DateLastRead, '') FROM content WHERE ContentID = ? AND ContentType = '6'", ContentID(from))
+			if err != nil {
+				return err
+			}
+			if len(values) > 0 {
+				lastRead[from] = values[0]
+			}
+			froms = append(froms, from)
+		}
+		sort.Slice(froms, func(i, j int) bool {
+			if lastRead[froms[i]] != lastRead[froms[j]] {
+				return lastRead[froms[i]] > lastRead[froms[j]]
+			}
+			return froms[i] < froms[j]
+		})
+
+		for _, from := range froms {
+			oldID, newID := ContentID(from), ContentID(moves[from])
+
+			// A book is already at the destination, the moved one is a duplicate
+			taken, err := t.column("SELECT ContentID FROM content WHERE ContentID = ?", newID)
+			if err != nil {
+				return err
+			}
+			if len(taken) > 0 && oldID != newID {
+				if _, err := removeBook(t, oldID, modified); err != nil {
+					return err
+				}
+				continue
+			}
+
+			n, err := t.exec("UPDATE content SET ContentID = ? WHERE ContentID = ? AND ContentType = '6'", newID, oldID)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				continue
+			}
+			moved++
+
+			// The IDs of the chapters of kepubs start with the ID of their book
+			statements := []string{
+				"UPDATE content SET ContentID = ?2 || substr(ContentID, length(?1) + 1) WHERE BookID = ?1 AND substr(ContentID, 1, length(?1)) = ?1",
+				"UPDATE content SET BookID = ?2 WHERE BookID = ?1",
+				"UPDATE volume_shortcovers SET shortcoverId = ?2 || substr(shortcoverId, length(?1) + 1) WHERE volumeId = ?1 AND substr(shortcoverId, 1, length(?1)) = ?1",
+				"UPDATE volume_shortcovers SET volumeId = ?2 WHERE volumeId = ?1",
+				"UPDATE Bookmark SET ContentID = ?2 || substr(ContentID, length(?1) + 1) WHERE VolumeID = ?1 AND substr(ContentID, 1, length(?1)) = ?1",
+				"UPDATE Bookmark SET VolumeID = ?2 WHERE VolumeID = ?1",
+				"UPDATE ShelfContent SET ContentId = ?2, _IsSynced = 'false' WHERE ContentId = ?1",
+			}
+			for _, statement := range statements {
+				if _, err := t.exec(statement, oldID, newID); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,167 @@
+package nickel
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	// Pure Go SQLite driver, kloud is cross-compiled without cgo
+	_ "modernc.org/sqlite"
+)
+
+// sqliteBusy is the result code of SQLite when another connection holds a lock on the database
+const sqliteBusy = 5
+
+// timeFormat is how Nickel writes dates in its database
+const timeFormat = "2006-01-02T15:04:05Z"
+
+// Errors returned when updating the database of Nickel
+var (
+	ErrLocked = errors.New("the database is locked by Nickel")
+)
+
+// DB is the database of Nickel, the reading software of the Kobo, holding its library (KoboReader.sqlite)
+type DB struct {
+	db *sql.DB
+
+	// backup is where the database is backed up before it is first changed, empty to not back it up
+	backup   string
+	backedUp bool
+}
+
+// Open opens the database of Nickel at path, which must exist
+func Open(path string) (*DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The statements of a transaction must share its connection
+	db.SetMaxOpenConns(1)
+	return &DB{db: db}, nil
+}
+
+// Close closes the database
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// SetBackup makes the updates back the database up to path before they first change it. Updates with nothing to
+// change leave the database and its backup alone.
+func (d *DB) SetBackup(path string) {
+	d.backup = path
+}
+
+// Backup writes a consistent copy of the database to path, only replacing the previous backup once written
+func (d *DB) Backup(path string) error {
+	os.Remove(path + ".tmp")
+	if _, err := d.db.Exec("VACUUM INTO ?", path+".tmp"); err != nil {
+		os.Remove(path + ".tmp")
+		return lockError(err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// tx runs the statements of a transaction
+type tx struct {
+	ctx  context.Context
+	conn *sql.Conn
+	// changed counts the rows changed by the transaction
+	changed *int64
+}
+
+// exec runs a statement and returns the number of rows it changed
+func (t tx) exec(query string, args ...interface{}) (int64, error) {
+	result, err := t.conn.ExecContext(t.ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	*t.changed += n
+	return n, err
+}
+
+// column runs a query returning a single column of strings
+func (t tx) column(query string, args ...interface{}) ([]string, error) {
+	rows, err := t.conn.QueryContext(t.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// update runs fn in a transaction. The transaction takes the write lock from the start, so it fails right away
+// with ErrLocked when Nickel is writing rather than half way through. When the database is not backed up yet, fn
+// first runs in a transaction that is rolled back, to only take the backup when fn changes something.
+func (d *DB) update(fn func(tx) error) error {
+	if d.backup != "" && d.backedUp == false {
+		changed, err := d.run(fn, false)
+		if err != nil || changed == 0 {
+			return err
+		}
+
+		if err := d.Backup(d.backup); err != nil {
+			return err
+		}
+		d.backedUp = true
+	}
+
+	_, err := d.run(fn, true)
+	return err
+}
+
+// run runs fn in a transaction, committed when commit is set and rolled back otherwise, and returns the number
+// of rows it changed
+func (d *DB) run(fn func(tx) error, commit bool) (int64, error) {
+	ctx := context.Background()
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return 0, lockError(err)
+	}
+
+	var changed int64
+	if err := fn(tx{ctx: ctx, conn: conn, changed: &changed}); err != nil {
+		conn.ExecContext(ctx, "ROLLBACK")
+		return 0, lockError(err)
+	}
+
+	end := "ROLLBACK"
+	if commit {
+		end = "COMMIT"
+	}
+	if _, err := conn.ExecContext(ctx, end); err != nil {
+		conn.ExecContext(ctx, "ROLLBACK")
+		return 0, lockError(err)
+	}
+	return changed, nil
+}
+
+// lockError reports the errors caused by another connection holding a lock as ErrLocked
+func lockError(err error) error {
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqliteBusy {
+		return fmt.Errorf("%w: %v", ErrLocked, err)
+	}
+	return err
+}
@@ -0,0 +1,393 @@
+package nickel
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newFixture creates a database of Nickel from the fixture and returns its path
+func newFixture(t *testing.T) string {
+	schema, err := ioutil.ReadFile(filepath.Join("testdata", "KoboReader.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "KoboReader.sqlite")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func count(t *testing.T, path, query string, args ...interface{}) int {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var n int
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestRemoveBooks(t *testing.T) {
+	path := newFixture(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	backup := filepath.Join(t.TempDir(), "KoboReader.sqlite.bak")
+	if err := db.Backup(backup); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := db.RemoveBooks([]string{"/mnt/onboard/KloudSync/Herbert/Dune.kepub.epub", "/mnt/sd/KloudSync/Herbert/Dune.kepub.epub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 book removed, got %d", removed)
+	}
+
+	dune := ContentID("/mnt/onboard/KloudSync/Herbert/Dune.kepub.epub")
+	if n := count(t, path, "SELECT COUNT(*) FROM content WHERE ContentID = ? OR BookID = ?", dune, dune); n != 0 {
+		t.Errorf("expected the book and its chapters to be removed, %d rows left", n)
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM volume_shortcovers"); n != 0 {
+		t.Errorf("expected the shortcovers to be removed, %d rows left", n)
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM ShelfContent WHERE _IsDeleted = 'true'"); n != 1 {
+		t.Errorf("expected the book to be marked deleted in its collection")
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM content"); n != 1 {
+		t.Errorf("expected the other book to be kept, %d rows left", n)
+	}
+
+	// The backup was taken before the removal
+	if n := count(t, backup, "SELECT COUNT(*) FROM content"); n != 3 {
+		t.Errorf("expected 3 rows in the backup, got %d", n)
+	}
+}
+
+func TestRemoveBooksLocked(t *testing.T) {
+	path := newFixture(t)
+
+	// Hold the write lock as Nickel does while it updates its library
+	nickel, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nickel.Close()
+	nickel.SetMaxOpenConns(1)
+	if _, err := nickel.Exec("BEGIN EXCLUSIVE"); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.RemoveBooks([]string{"/mnt/onboard/KloudSync/Asimov/Foundation.epub"}); errors.Is(err, ErrLocked) == false {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+
+	nickel.Exec("ROLLBACK")
+	if n := count(t, path, "SELECT COUNT(*) FROM content"); n != 3 {
+		t.Errorf("expected the library to be untouched, got %d rows", n)
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "KoboReader.sqlite")); err == nil {
+		t.Error("expected an error for a missing database")
+	}
+}
+
+func TestSyncCollections(t *testing.T) {
+	path := newFixture(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	roots := []string{"/mnt/onboard/KloudSync", "/mnt/sd/KloudSync"}
+	dune := "/mnt/onboard/KloudSync/Herbert/Dune.kepub.epub"
+	foundation := "/mnt/onboard/KloudSync/Asimov/Foundation.epub"
+
+	// Foundation joins a new collection, the book Nickel did not import yet is left for later
+	result, err := db.SyncCollections(map[string][]Book{
+		"Herbert": {{dune, "/mnt/sd/KloudSync/Herbert/Dune.kepub.epub"}},
+		"Asimov":  {{foundation}, {"/mnt/onboard/KloudSync/Asimov/I, Robot.epub"}},
+	}, nil, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Managed) != 2 || result.Unknown != 1 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM Shelf WHERE Name = 'Asimov' AND Type = 'UserTag' AND _IsDeleted = 'false'"); n != 1 {
+		t.Errorf("expected the Asimov collection to be created")
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM ShelfContent WHERE ShelfName = 'Asimov' AND ContentId = ? AND _IsDeleted = 'false'", ContentID(foundation)); n != 1 {
+		t.Errorf("expected Foundation in the Asimov collection")
+	}
+
+	// Foundation moves to another collection, Herbert is emptied, and collections kloud does not manage are kept
+	result, err = db.SyncCollections(map[string][]Book{"Classics": {{foundation}}}, result.Managed, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Managed) != 1 || result.Managed[0] != "Classics" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM ShelfContent WHERE ShelfName = 'Asimov' AND _IsDeleted = 'false'"); n != 0 {
+		t.Errorf("expected Foundation to leave the Asimov collection")
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM Shelf WHERE Name IN ('Asimov', 'Herbert') AND _IsDeleted = 'true'"); n != 2 {
+		t.Errorf("expected the empty collections to be deleted")
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM ShelfContent WHERE ShelfName = 'Favorites' AND _IsDeleted = 'false'"); n != 1 {
+		t.Errorf("expected the Favorites collection to be untouched")
+	}
+
+	// A deleted collection is restored when books join it again
+	if _, err := db.SyncCollections(map[string][]Book{"Asimov": {{foundation}}}, result.Managed, roots); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM ShelfContent WHERE ShelfName = 'Asimov' AND _IsDeleted = 'false'"); n != 1 {
+		t.Errorf("expected Foundation back in the Asimov collection")
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM Shelf WHERE Name = 'Asimov' AND _IsDeleted = 'false'"); n != 1 {
+		t.Errorf("expected the Asimov collection to be restored")
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	db, err := Open(newFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	books, err := db.Annotations([]string{"/mnt/onboard/KloudSync", "/mnt/sd/KloudSync"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 {
+		t.Fatalf("expected the annotations of 1 book, got %+v", books)
+	}
+
+	dune := books["/mnt/onboard/KloudSync/Herbert/Dune.kepub.epub"]
+	if dune.Title != "Dune" || dune.Author != "Frank Herbert" {
+		t.Errorf("unexpected book %+v", dune)
+	}
+	if len(dune.Annotations) != 2 {
+		t.Fatalf("expected 2 annotations, got %+v", dune.Annotations)
+	}
+
+	// Annotations are in reading order, and hidden ones were deleted on the device
+	highlight, note := dune.Annotations[0], dune.Annotations[1]
+	if highlight.ID != "b1" || highlight.Type != AnnotationHighlight || highlight.Chapter != "Chapter 1" {
+		t.Errorf("unexpected highlight %+v", highlight)
+	}
+	if note.ID != "b2" || note.Type != AnnotationNote || note.Note != "The litany against fear" || note.Text != "Fear is the mind-killer." {
+		t.Errorf("unexpected note %+v", note)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	path := newFixture(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	roots := []string{"/mnt/onboard/KloudSync", "/mnt/sd/KloudSync"}
+	progress, err := db.Progress(roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dune := progress["/mnt/onboard/KloudSync/Herbert/Dune.kepub.epub"]
+	if len(progress) != 2 || dune.Status != StatusReading || dune.PercentRead != 42 || dune.LastRead != "2021-05-02T20:15:00Z" {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+
+	// Dune was read more recently on the device, Foundation was never opened and a book not imported is skipped
+	updated, err := db.ImportProgress(map[string]Progress{
+		"/mnt/onboard/KloudSync/Herbert/Dune.kepub.epub": {Status: StatusFinished, PercentRead: 100, LastRead: "2021-05-01T08:00:00Z"},
+		"/mnt/onboard/KloudSync/Asimov/Foundation.epub":  {Status: StatusReading, PercentRead: 12, LastRead: "2021-05-03T08:00:00Z"},
+		"/mnt/onboard/KloudSync/Asimov/Robots.epub":      {Status: StatusReading, PercentRead: 50, LastRead: "2021-05-03T08:00:00Z"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 1 {
+		t.Errorf("expected 1 book updated, got %d", updated)
+	}
+
+	progress, err = db.Progress(roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := progress["/mnt/onboard/KloudSync/Herbert/Dune.kepub.epub"]; p != dune {
+		t.Errorf("expected the progress of Dune to be kept, got %+v", p)
+	}
+	if p := progress["/mnt/onboard/KloudSync/Asimov/Foundation.epub"]; p.Status != StatusReading || p.PercentRead != 12 || p.LastRead != "2021-05-03T08:00:00Z" {
+		t.Errorf("unexpected progress of Foundation %+v", p)
+	}
+}
+
+func TestMoveBooks(t *testing.T) {
+	path := newFixture(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	moved, err := db.MoveBooks(map[string]string{
+		"/mnt/onboard/KloudSync/Herbert/Dune.kepub.epub": "/mnt/onboard/Archive/Herbert/Dune.kepub.epub",
+		"/mnt/sd/KloudSync/Herbert/Dune.kepub.epub":      "/mnt/onboard/Archive/Herbert/Dune.kepub.epub",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 1 {
+		t.Errorf("expected 1 book moved, got %d", moved)
+	}
+
+	oldID := "file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub"
+	newID := "file:///mnt/onboard/Archive/Herbert/Dune.kepub.epub"
+	if n := count(t, path, "SELECT COUNT(*) FROM content WHERE ContentID = ? AND ReadStatus = 1 AND ___PercentRead = 42", newID); n != 1 {
+		t.Errorf("expected the book to keep its progress, got %d", n)
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM content WHERE BookID = ? AND ContentID = ?", newID, newID+"!!OEBPS/chapter1.xhtml"); n != 1 {
+		t.Errorf("expected the chapter to follow its book, got %d", n)
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM volume_shortcovers WHERE volumeId = ? AND shortcoverId = ?", newID, newID+"!!OEBPS/chapter1.xhtml"); n != 1 {
+		t.Errorf("expected the table of contents to follow its book, got %d", n)
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM Bookmark WHERE VolumeID = ? AND ContentID = ?", newID, newID+"!!OEBPS/chapter1.xhtml"); n != 3 {
+		t.Errorf("expected the bookmarks to follow their book, got %d", n)
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM ShelfContent WHERE ContentId = ?", newID); n != 1 {
+		t.Errorf("expected the book to stay in its collection, got %d", n)
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM content WHERE ContentID = ? OR BookID = ?", oldID, oldID); n != 0 {
+		t.Errorf("expected no content left at the previous path, got %d", n)
+	}
+}
+
+func TestMoveBooksMirror(t *testing.T) {
+	path := newFixture(t)
+	mirrorID := "file:///mnt/sd/KloudSync/Herbert/Dune.kepub.epub"
+	sqlDB, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sqlDB.Exec(`INSERT INTO content VALUES (?1, '6', 'application/x-kobo-epub+zip', NULL, NULL, 'Dune', 'Frank Herbert', '2021-04-01T10:00:00Z', 1, 10, 'adobe_user', -1);
+		INSERT INTO content VALUES (?1 || '!!OEBPS/chapter1.xhtml', '9', 'application/xhtml+xml', ?1, 'Dune', 'Chapter 1', NULL, NULL, 0, 0, 'adobe_user', 0);
+		INSERT INTO ShelfContent VALUES ('Herbert', ?1, '2021-05-01T10:00:00Z', 'false', 'true');`, mirrorID)
+	sqlDB.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Nickel knows the book by both its paths, which are archived to the same one
+	moved, err := db.MoveBooks(map[string]string{
+		"/mnt/onboard/KloudSync/Herbert/Dune.kepub.epub": "/mnt/onboard/Archive/Herbert/Dune.kepub.epub",
+		"/mnt/sd/KloudSync/Herbert/Dune.kepub.epub":      "/mnt/onboard/Archive/Herbert/Dune.kepub.epub",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 1 {
+		t.Errorf("expected 1 book moved, got %d", moved)
+	}
+
+	newID := "file:///mnt/onboard/Archive/Herbert/Dune.kepub.epub"
+	if n := count(t, path, "SELECT COUNT(*) FROM content WHERE ContentID = ? AND ___PercentRead = 42", newID); n != 1 {
+		t.Errorf("expected the most recently read book to be moved, got %d", n)
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM content WHERE ContentID = ? OR BookID = ?", mirrorID, mirrorID); n != 0 {
+		t.Errorf("expected the duplicate to be removed, got %d", n)
+	}
+	if n := count(t, path, "SELECT COUNT(*) FROM ShelfContent WHERE ContentId = ? AND _IsDeleted = 'false'", newID); n != 1 {
+		t.Errorf("expected the book to stay in its collection, got %d", n)
+	}
+}
+
+func TestProgressReading(t *testing.T) {
+	since := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		progress Progress
+		since    time.Time
+		reading  bool
+	}{
+		{Progress{Status: StatusReading}, time.Time{}, true},
+		{Progress{Status: StatusFinished, LastRead: "2021-05-02T20:15:00Z"}, time.Time{}, false},
+		{Progress{Status: StatusFinished, LastRead: "2021-05-02T20:15:00Z"}, since, true},
+		{Progress{Status: StatusFinished, LastRead: "2021-04-02T20:15:00Z"}, since, false},
+		{Progress{Status: StatusUnread}, since, false},
+	}
+	for _, test := range tests {
+		if reading := test.progress.Reading(test.since); reading != test.reading {
+			t.Errorf("expected %+v since %v to be reading: %v, got %v", test.progress, test.since, test.reading, reading)
+		}
+	}
+}
+
+func TestUpdateBackup(t *testing.T) {
+	db, err := Open(newFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	backup := filepath.Join(t.TempDir(), "KoboReader.sqlite.bak")
+	db.SetBackup(backup)
+	roots := []string{"/mnt/onboard/KloudSync"}
+	collections := map[string][]Book{"Herbert": {{"/mnt/onboard/KloudSync/Herbert/Dune.kepub.epub"}}}
+
+	// The collections are already up to date
+	if _, err := db.SyncCollections(collections, []string{"Herbert"}, roots); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(backup); os.IsNotExist(err) == false {
+		t.Errorf("expected no backup without changes: %v", err)
+	}
+
+	collections["Asimov"] = []Book{{"/mnt/onboard/KloudSync/Asimov/Foundation.epub"}}
+	if _, err := db.SyncCollections(collections, []string{"Herbert"}, roots); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, backup, "SELECT COUNT(*) FROM Shelf WHERE Name = 'Asimov'"); n != 0 {
+		t.Errorf("expected a backup taken before the changes, got %d new collections in it", n)
+	}
+}
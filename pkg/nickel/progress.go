@@ -0,0 +1,94 @@
+package nickel
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Reading statuses of the books, as Nickel records them
+const (
+	StatusUnread   = 0
+	StatusReading  = 1
+	StatusFinished = 2
+)
+
+// Progress is how far a book was read
+type Progress struct {
+	Status      int
+	PercentRead int
+	// LastRead is when the book was last opened, in the format of Nickel, empty if never
+	LastRead string
+}
+
+// Reading reports whether the book is marked as being read, or was opened since the given time when it is not zero
+func (p Progress) Reading(since time.Time) bool {
+	if p.Status == StatusReading {
+		return true
+	}
+	return since.IsZero() == false && p.LastRead != "" && p.LastRead >= since.UTC().Format(timeFormat)
+}
+
+// Progress returns the progress of the books under roots, by the path of the books as seen by Nickel
+func (d *DB) Progress(roots []string) (map[string]Progress, error) {
+	books := map[string]Progress{}
+
+	for _, root := range roots {
+		prefix := ContentID(root) + "/"
+		rows, err := d.db.Query(`SELECT ContentID, ReadStatus, ___PercentRead, DateLastRead FROM content
+			WHERE ContentType = '6' AND substr(ContentID, 1, ?) = ?`, len(prefix), prefix)
+		if err != nil {
+			return nil, lockError(err)
+		}
+
+		for rows.Next() {
+			var (
+				contentID    string
+				status, read sql.NullInt64
+				lastRead     sql.NullString
+				p            Progress
+			)
+			if err := rows.Scan(&contentID, &status, &read, &lastRead); err != nil {
+				rows.Close()
+				return nil, lockError(err)
+			}
+
+			p.Status, p.PercentRead, p.LastRead = int(status.Int64), int(read.Int64), lastRead.String
+			books[strings.TrimPrefix(contentID, "file://")] = p
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, lockError(err)
+		}
+	}
+
+	return books, nil
+}
+
+// ImportProgress sets the progress of the books at the given paths, as seen by Nickel, in a single transaction.
+// Books read more recently on the device keep their progress, and paths Nickel does not know are ignored.
+// It returns the number of books updated.
+func (d *DB) ImportProgress(progress map[string]Progress) (int, error) {
+	updated := 0
+
+	err := d.update(func(t tx) error {
+		updated = 0
+		for path, p := range progress {
+			n, err := t.exec(`UPDATE content SET ReadStatus = ?, ___PercentRead = ?, DateLastRead = ?
+				WHERE ContentID = ? AND ContentType = '6' AND (DateLastRead IS NULL OR DateLastRead < ?)`,
+				p.Status, p.PercentRead, p.LastRead, ContentID(path), p.LastRead)
+			if err != nil {
+				return err
+			}
+			updated += int(n)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,137 @@
+package nickel
+
+import (
+	"strings"
+	"time"
+)
+
+// shelfType is the type of the collections created on the device
+const shelfType = "UserTag"
+
+// Book lists the paths Nickel may know a book by
+type Book []string
+
+// Collections is the result of SyncCollections
+type Collections struct {
+	// Managed lists the collections kloud now maintains
+	Managed []string
+	// Unknown is the number of books Nickel did not import yet, to add on a later update
+	Unknown int
+}
+
+// SyncCollections makes the collections of Nickel (shelves) match the given ones, mapping their names to their
+// books. Books under roots are removed from the collections kloud managed so far when they are no longer listed in
+// them, and these collections are deleted once empty. Other books and collections are left alone, and books Nickel
+// did not import yet are skipped.
+func (d *DB) SyncCollections(collections map[string][]Book, managed []string, roots []string) (Collections, error) {
+	var result Collections
+	modified := time.Now().UTC().Format(timeFormat)
+
+	err := d.update(func(t tx) error {
+		result = Collections{}
+
+		// Only the books of the library of Nickel can be added to collections
+		known := map[string]bool{}
+		for _, root := range roots {
+			prefix := ContentID(root) + "/"
+			ids, err := t.column("SELECT ContentID FROM content WHERE ContentType = '6' AND substr(ContentID, 1, ?) = ?", len(prefix), prefix)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				known[id] = true
+			}
+		}
+
+		listed := map[string]map[string]bool{}
+		for name, books := range collections {
+			listed[name] = map[string]bool{}
+			for _, book := range books {
+				imported := false
+				for _, path := range book {
+					if known[ContentID(path)] {
+						listed[name][ContentID(path)] = true
+						imported = true
+					}
+				}
+				if imported == false {
+					result.Unknown++
+				}
+			}
+			if len(listed[name]) == 0 {
+				continue
+			}
+
+			if err := ensureShelf(t, name, modified); err != nil {
+				return err
+			}
+			for id := range listed[name] {
+				_, err := t.exec(`INSERT INTO ShelfContent (ShelfName, ContentId, DateModified, _IsDeleted, _IsSynced) VALUES (?, ?, ?, 'false', 'false')
+					ON CONFLICT (ShelfName, ContentId) DO UPDATE SET _IsDeleted = 'false', _IsSynced = 'false', DateModified = excluded.DateModified
+					WHERE _IsDeleted = 'true'`, name, id, modified)
+				if err != nil {
+					return err
+				}
+			}
+			result.Managed = append(result.Managed, name)
+		}
+
+		// Take the books out of the collections they left, and the collections out of the library once empty
+		names := map[string]bool{}
+		for _, name := range append(append([]string{}, managed...), result.Managed...) {
+			names[name] = true
+		}
+		for name := range names {
+			ids, err := t.column("SELECT ContentId FROM ShelfContent WHERE ShelfName = ? AND _IsDeleted != 'true'", name)
+			if err != nil {
+				return err
+			}
+
+			left := len(ids)
+			for _, id := range ids {
+				if listed[name][id] || underRoots(id, roots) == false {
+					continue
+				}
+				if _, err := t.exec("UPDATE ShelfContent SET _IsDeleted = 'true', _IsSynced = 'false', DateModified = ? WHERE ShelfName = ? AND ContentId = ?", modified, name, id); err != nil {
+					return err
+				}
+				left--
+			}
+
+			if left == 0 && len(listed[name]) == 0 {
+				if _, err := t.exec("UPDATE Shelf SET _IsDeleted = 'true', _IsSynced = 'false', LastModified = ? WHERE Name = ? AND _IsDeleted != 'true'", modified, name); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Collections{}, err
+	}
+
+	return result, nil
+}
+
+// ensureShelf creates the collection, or restores it if it was deleted
+func ensureShelf(t tx, name, modified string) error {
+	n, err := t.exec("UPDATE Shelf SET _IsDeleted = 'false', _IsVisible = 'true', _IsSynced = 'false', LastModified = ? WHERE Name = ? AND _IsDeleted = 'true'", modified, name)
+	if err != nil || n > 0 {
+		return err
+	}
+
+	_, err = t.exec(`INSERT INTO Shelf (CreationDate, Id, InternalName, LastModified, Name, Type, _IsDeleted, _IsVisible, _IsSynced)
+		SELECT ?, ?, ?, ?, ?, ?, 'false', 'true', 'false' WHERE NOT EXISTS (SELECT 1 FROM Shelf WHERE Name = ?)`,
+		modified, name, name, modified, name, shelfType, name)
+	return err
+}
+
+// underRoots reports whether a book is under one of the roots
+func underRoots(contentID string, roots []string) bool {
+	for _, root := range roots {
+		if strings.HasPrefix(contentID, ContentID(root)+"/") {
+			return true
+		}
+	}
+	return false
+}
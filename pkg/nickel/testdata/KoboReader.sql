@@ -0,0 +1,74 @@
+-- Fixture of the database of Nickel, with the tables and columns kloud uses taken from firmware 4.x
+CREATE TABLE content (
+	ContentID TEXT NOT NULL,
+	ContentType TEXT NOT NULL,
+	MimeType TEXT NOT NULL,
+	BookID TEXT,
+	BookTitle TEXT,
+	Title TEXT COLLATE NOCASE,
+	Attribution TEXT COLLATE NOCASE,
+	DateLastRead TEXT,
+	ReadStatus INTEGER,
+	___PercentRead INTEGER,
+	___UserID TEXT NOT NULL,
+	VolumeIndex INTEGER,
+	PRIMARY KEY (ContentID)
+);
+
+CREATE TABLE volume_shortcovers (
+	volumeId TEXT NOT NULL,
+	shortcoverId TEXT NOT NULL,
+	VolumeIndex INTEGER,
+	PRIMARY KEY (volumeId, shortcoverId)
+);
+
+CREATE TABLE Shelf (
+	CreationDate TEXT,
+	Id TEXT,
+	InternalName TEXT,
+	LastModified TEXT,
+	Name TEXT,
+	Type TEXT,
+	_IsDeleted BOOL,
+	_IsVisible BOOL,
+	_IsSynced BOOL,
+	_SyncTime TEXT,
+	LastAccessed TEXT,
+	PRIMARY KEY (Id)
+);
+
+CREATE TABLE ShelfContent (
+	ShelfName TEXT,
+	ContentId TEXT,
+	DateModified TEXT,
+	_IsDeleted BOOL,
+	_IsSynced BOOL,
+	PRIMARY KEY (ShelfName, ContentId)
+);
+
+CREATE TABLE Bookmark (
+	BookmarkID TEXT NOT NULL,
+	VolumeID TEXT NOT NULL,
+	ContentID TEXT NOT NULL,
+	Text TEXT,
+	Annotation TEXT,
+	DateCreated TEXT,
+	ChapterProgress REAL NOT NULL DEFAULT 0,
+	Hidden BOOL NOT NULL DEFAULT 0,
+	DateModified TEXT,
+	Type TEXT,
+	PRIMARY KEY (BookmarkID)
+);
+
+INSERT INTO content VALUES ('file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub', '6', 'application/x-kobo-epub+zip', NULL, NULL, 'Dune', 'Frank Herbert', '2021-05-02T20:15:00Z', 1, 42, 'adobe_user', -1);
+INSERT INTO content VALUES ('file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub!!OEBPS/chapter1.xhtml', '9', 'application/xhtml+xml', 'file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub', 'Dune', 'Chapter 1', NULL, NULL, 0, 0, 'adobe_user', 0);
+INSERT INTO content VALUES ('file:///mnt/onboard/KloudSync/Asimov/Foundation.epub', '6', 'application/epub+zip', NULL, NULL, 'Foundation', 'Isaac Asimov', NULL, 0, 0, 'adobe_user', -1);
+INSERT INTO volume_shortcovers VALUES ('file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub', 'file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub!!OEBPS/chapter1.xhtml', 0);
+INSERT INTO Shelf VALUES ('2021-05-01T10:00:00Z', 'Herbert', 'Herbert', '2021-05-01T10:00:00Z', 'Herbert', 'UserTag', 'false', 'true', 'false', NULL, NULL);
+INSERT INTO Shelf VALUES ('2021-05-01T10:00:00Z', 'Favorites', 'Favorites', '2021-05-01T10:00:00Z', 'Favorites', 'UserTag', 'false', 'true', 'false', NULL, NULL);
+INSERT INTO ShelfContent VALUES ('Herbert', 'file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub', '2021-05-01T10:00:00Z', 'false', 'true');
+INSERT INTO ShelfContent VALUES ('Favorites', 'file:///mnt/onboard/KloudSync/Asimov/Foundation.epub', '2021-05-01T10:00:00Z', 'false', 'true');
+INSERT INTO Bookmark VALUES ('b2', 'file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub', 'file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub!!OEBPS/chapter1.xhtml', 'Fear is the mind-killer.', 'The litany against fear', '2021-05-02T20:10:00Z', 0.5, 'false', '2021-05-02T20:11:00Z', 'note');
+INSERT INTO Bookmark VALUES ('b1', 'file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub', 'file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub!!OEBPS/chapter1.xhtml', 'A beginning is the time for taking the most delicate care.', NULL, '2021-05-02T20:00:00Z', 0.1, 'false', NULL, 'highlight');
+INSERT INTO Bookmark VALUES ('b3', 'file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub', 'file:///mnt/onboard/KloudSync/Herbert/Dune.kepub.epub!!OEBPS/chapter1.xhtml', 'Deleted highlight', NULL, '2021-05-02T20:12:00Z', 0.7, 'true', NULL, 'highlight');
+INSERT INTO Bookmark VALUES ('b4', 'file:///mnt/onboard/Other/Book.epub', 'file:///mnt/onboard/Other/Book.epub', 'Not synced by kloud', NULL, '2021-05-02T20:12:00Z', 0, 'false', NULL, 'highlight');
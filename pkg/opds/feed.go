@@ -0,0 +1,103 @@
+package opds
+
+import (
+	"strings"
+	"time"
+)
+
+// Relations of the links of an OPDS feed
+const (
+	relNext        = "next"
+	relSubsection  = "subsection"
+	relAcquisition = "http://opds-spec.org/acquisition"
+)
+
+// Media types of the formats kloud can download, by order of preference
+var formats = []struct {
+	types     []string
+	extension string
+}{
+	{[]string{"application/kepub+zip", "application/x-kobo-epub+zip"}, ".kepub.epub"},
+	{[]string{"application/epub+zip"}, ".epub"},
+	{[]string{"application/pdf"}, ".pdf"},
+}
+
+// link is a link of an OPDS feed or entry
+type link struct {
+	Rel    string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr"`
+	Length int64  `xml:"length,attr"`
+}
+
+// entry is an entry of an OPDS feed, either a publication or a link to another feed
+type entry struct {
+	ID      string `xml:"id"`
+	Title   string `xml:"title"`
+	Updated string `xml:"updated"`
+	Links   []link `xml:"link"`
+}
+
+// feed is an OPDS navigation or acquisition feed
+type feed struct {
+	Title   string  `xml:"title"`
+	Links   []link  `xml:"link"`
+	Entries []entry `xml:"entry"`
+}
+
+// mediaType returns the media type of a link without its parameters
+func (l link) mediaType() string {
+	return strings.TrimSpace(strings.SplitN(l.Type, ";", 2)[0])
+}
+
+// isFeed reports whether the link points to another OPDS feed
+func (l link) isFeed() bool {
+	return l.mediaType() == "application/atom+xml" && strings.HasPrefix(l.Rel, relAcquisition) == false
+}
+
+// next returns the link to the next page of the feed, if any
+func (f feed) next() (link, bool) {
+	for _, l := range f.Links {
+		if l.Rel == relNext && l.isFeed() {
+			return l, true
+		}
+	}
+	return link{}, false
+}
+
+// acquisition returns the acquisition link of the preferred format of a publication and its file extension
+func (e entry) acquisition() (link, string, bool) {
+	for _, format := range formats {
+		for _, l := range e.Links {
+			if strings.HasPrefix(l.Rel, relAcquisition) == false {
+				continue
+			}
+
+			for _, mediaType := range format.types {
+				if l.mediaType() == mediaType {
+					return l, format.extension, true
+				}
+			}
+		}
+	}
+	return link{}, "", false
+}
+
+// subfeed returns the link to the feed an entry of a navigation feed points to, if any
+func (e entry) subfeed() (link, bool) {
+	for _, l := range e.Links {
+		if l.isFeed() && (l.Rel == "" || l.Rel == relSubsection || strings.HasPrefix(l.Rel, "http://opds-spec.org/")) {
+			return l, true
+		}
+	}
+	return link{}, false
+}
+
+// updated returns the last update time of the entry, or the zero time if it is missing or invalid
+func (e entry) updated() time.Time {
+	updated, err := time.Parse(time.RFC3339, strings.TrimSpace(e.Updated))
+	if err != nil {
+		return time.Time{}
+	}
+	return updated
+}
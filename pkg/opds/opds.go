@@ -0,0 +1,230 @@
+// Package opds syncs a library from an OPDS catalog, crawling its navigation feeds for the publications to download.
+package opds
+
+import (
+	"crypto/sha1"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+
+	"kloud/pkg/backend"
+)
+
+// maxDepth protects the crawl against catalogs exposing a never-ending hierarchy
+const maxDepth = 16
+
+// Errors returned when creating the client and downloading publications
+var (
+	ErrUnknownFolders = errors.New("unknown folders mode")
+	ErrUnknownBook    = errors.New("book was not found in the catalog")
+)
+
+// Folders is the way books are arranged in folders
+type Folders string
+
+// Folders modes supported by the client
+const (
+	// FoldersHierarchy puts books in nested folders named after the navigation feeds leading to them
+	FoldersHierarchy Folders = "hierarchy"
+	// FoldersFeed puts books in a folder named after the feed listing them, such as their series
+	FoldersFeed Folders = "feed"
+	// FoldersFlat puts every book at the root of the library
+	FoldersFlat Folders = "flat"
+)
+
+// Client crawls an OPDS catalog, such as the ones of Calibre-Web, Kavita, Komga or COPS
+type Client struct {
+	http     http.Client
+	url      string
+	user     string
+	password string
+	folders  Folders
+
+	// books maps the path of the books found by List to their acquisition URL
+	books map[string]string
+}
+
+// Client only lists and downloads, catalogs cannot tell what changed
+var _ backend.Backend = (*Client)(nil)
+
+// NewClient creates a new OPDS client for the catalog at catalogURL with the configured TLS settings.
+// user may be empty for catalogs that do not require authentication.
+func NewClient(cacert []byte, catalogURL, user, password string, folders Folders) (*Client, error) {
+	httpClient, err := backend.NewHTTPClient(cacert)
+	if err != nil {
+		return nil, err
+	}
+
+	switch folders {
+	case "":
+		folders = FoldersHierarchy
+	case FoldersHierarchy, FoldersFeed, FoldersFlat:
+	default:
+		return nil, ErrUnknownFolders
+	}
+
+	return &Client{
+		http:     httpClient,
+		url:      catalogURL,
+		user:     user,
+		password: password,
+		folders:  folders,
+		books:    map[string]string{},
+	}, nil
+}
+
+// page is a feed waiting to be crawled
+type page struct {
+	url     string
+	folders []string
+	depth   int
+}
+
+// List crawls the catalog breadth-first and calls fn for every publication with a supported format.
+// Publications are identified by their id, so a book listed in several feeds is only returned once.
+// Their path ends with a hash of their id, so that books sharing a title keep their path whatever else the catalog
+// holds.
+func (c *Client) List(fn func(backend.Entry) error) error {
+	var (
+		queue   = []page{{url: c.url}}
+		visited = map[string]bool{}
+		seen    = map[string]bool{}
+	)
+	c.books = map[string]string{}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if visited[current.url] {
+			continue
+		}
+		visited[current.url] = true
+
+		f, err := c.fetch(current.url)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range f.Entries {
+			// Publications are downloaded, other entries lead to more feeds
+			if l, extension, ok := e.acquisition(); ok {
+				href, err := resolve(current.url, l.Href)
+				if err != nil {
+					return err
+				}
+
+				id := e.ID
+				if id == "" {
+					id = href
+				}
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+
+				book := backend.Entry{Path: c.bookPath(current.folders, e.Title, id, extension), Size: -1, ModTime: e.updated()}
+				if l.Length > 0 {
+					book.Size = l.Length
+				}
+				c.books[book.Path] = href
+
+				if err := fn(book); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if l, ok := e.subfeed(); ok && current.depth < maxDepth {
+				href, err := resolve(current.url, l.Href)
+				if err != nil {
+					return err
+				}
+
+				folders := append(append([]string{}, current.folders...), backend.SanitizeName(e.Title))
+				queue = append(queue, page{url: href, folders: folders, depth: current.depth + 1})
+			}
+		}
+
+		// The next page of a feed belongs to the same folder
+		if l, ok := f.next(); ok {
+			href, err := resolve(current.url, l.Href)
+			if err != nil {
+				return err
+			}
+			queue = append(queue, page{url: href, folders: current.folders, depth: current.depth})
+		}
+	}
+
+	return nil
+}
+
+// bookPath returns the path of a book from its title, followed by a short hash of its id telling apart the books
+// sharing a title
+func (c *Client) bookPath(folders []string, title, id, extension string) string {
+	var folder string
+	switch c.folders {
+	case FoldersHierarchy:
+		folder = path.Join(folders...)
+	case FoldersFeed:
+		if len(folders) > 0 {
+			folder = folders[len(folders)-1]
+		}
+	}
+
+	sum := sha1.Sum([]byte(id))
+	return path.Join(folder, fmt.Sprintf("%s (%x)%s", backend.SanitizeName(title), sum[:4], extension))
+}
+
+// Open downloads a book found by List and returns a stream of its contents
+func (c *Client) Open(bookPath string) (io.ReadCloser, error) {
+	href, ok := c.books[bookPath]
+	if ok == false {
+		return nil, ErrUnknownBook
+	}
+
+	resp, err := c.get(href)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// fetch downloads and parses a feed
+func (c *Client) fetch(feedURL string) (feed, error) {
+	resp, err := c.get(feedURL)
+	if err != nil {
+		return feed{}, err
+	}
+	defer resp.Body.Close()
+
+	var f feed
+	if err := xml.NewDecoder(resp.Body).Decode(&f); err != nil {
+		return feed{}, err
+	}
+	return f, nil
+}
+
+// get performs an authenticated GET request and checks its status
+func (c *Client) get(rawURL string) (*http.Response, error) {
+	return backend.Do(&c.http, "GET", rawURL, c.user, c.password)
+}
+
+// resolve resolves a link of a feed against the URL of the feed
+func resolve(base, href string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+
+	return baseURL.ResolveReference(ref).String(), nil
+}
@@ -0,0 +1,205 @@
+package opds
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kloud/pkg/backend"
+)
+
+const feedTpl = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:opds="http://opds-spec.org/2010/catalog"><title>%s</title>%s</feed>`
+
+var testFeeds = map[string]string{
+	"/opds": fmt.Sprintf(feedTpl, "Catalog", `
+<entry><title>By series</title><id>series</id><link rel="subsection" href="/opds/series" type="application/atom+xml;profile=opds-catalog;kind=navigation"/></entry>
+<entry><title>Recent</title><id>recent</id><link href="opds/recent" type="application/atom+xml;profile=opds-catalog;kind=acquisition"/></entry>`),
+	"/opds/series": fmt.Sprintf(feedTpl, "By series", `
+<entry><title>Dune</title><id>dune</id><link rel="subsection" href="/opds/series/dune" type="application/atom+xml;profile=opds-catalog;kind=acquisition"/></entry>
+<entry><title>Home</title><id>home</id><link rel="start" href="/opds" type="application/atom+xml;profile=opds-catalog;kind=navigation"/></entry>`),
+	"/opds/series/dune": fmt.Sprintf(feedTpl, "Dune", `
+<link rel="next" href="/opds/series/dune?page=2" type="application/atom+xml;profile=opds-catalog;kind=acquisition"/>
+<entry><title>Dune</title><id>urn:book:1</id><updated>2021-11-28T18:30:15Z</updated>
+<link rel="http://opds-spec.org/acquisition" href="/download/1.epub" type="application/epub+zip"/>
+<link rel="http://opds-spec.org/acquisition" href="/download/1.kepub" type="application/kepub+zip"/></entry>`),
+	"/opds/series/dune?page=2": fmt.Sprintf(feedTpl, "Dune", `
+<entry><title>Dune Messiah</title><id>urn:book:2</id>
+<link rel="http://opds-spec.org/acquisition/open-access" href="/download/2.pdf" type="application/pdf" length="42"/></entry>
+<entry><title>Children of Dune</title><id>urn:book:3</id>
+<link rel="http://opds-spec.org/acquisition" href="/download/3.mobi" type="application/x-mobipocket-ebook"/></entry>`),
+	"/opds/recent": fmt.Sprintf(feedTpl, "Recent", `
+<entry><title>Dune</title><id>urn:book:1</id>
+<link rel="http://opds-spec.org/acquisition" href="/download/1.kepub" type="application/kepub+zip"/></entry>
+<entry><title>A/B</title><id>urn:book:4</id>
+<link rel="http://opds-spec.org/acquisition" href="/download/4.epub" type="application/epub+zip"/></entry>`),
+}
+
+func newTestServer() (*httptest.Server, []byte) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "alice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/download/") {
+			fmt.Fprint(w, r.URL.Path)
+			return
+		}
+
+		content, ok := testFeeds[r.URL.RequestURI()]
+		if ok == false {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, content)
+	}))
+
+	return srv, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+}
+
+func TestList(t *testing.T) {
+	srv, cacert := newTestServer()
+	defer srv.Close()
+
+	list := func(t *testing.T, folders Folders) (*Client, []backend.Entry) {
+		client, err := NewClient(cacert, srv.URL+"/opds", "alice", "secret", folders)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var entries []backend.Entry
+		err = client.List(func(entry backend.Entry) error {
+			entries = append(entries, entry)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client, entries
+	}
+
+	paths := func(entries []backend.Entry) string {
+		var ret []string
+		for _, entry := range entries {
+			ret = append(ret, entry.Path)
+		}
+		return strings.Join(ret, ",")
+	}
+
+	t.Run("Hierarchy", func(t *testing.T) {
+		client, entries := list(t, "")
+
+		expected := "Recent/Dune (94a8dab1).kepub.epub,Recent/A_B (4fbb46e9).epub,By series/Dune/Dune Messiah (a2e792d6).pdf"
+		if paths(entries) != expected {
+			t.Errorf("expected %s, got %s", expected, paths(entries))
+		}
+		if entries[2].Size != 42 || entries[0].Size != -1 {
+			t.Errorf("unexpected sizes in %+v", entries)
+		}
+
+		body, err := client.Open("By series/Dune/Dune Messiah (a2e792d6).pdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer body.Close()
+		content, _ := ioutil.ReadAll(body)
+		if string(content) != "/download/2.pdf" {
+			t.Errorf("unexpected content %s", content)
+		}
+
+		if _, err := client.Open("Dune.epub"); err != ErrUnknownBook {
+			t.Errorf("expected %v, got %v", ErrUnknownBook, err)
+		}
+	})
+
+	t.Run("Feed", func(t *testing.T) {
+		_, entries := list(t, FoldersFeed)
+
+		expected := "Recent/Dune (94a8dab1).kepub.epub,Recent/A_B (4fbb46e9).epub,Dune/Dune Messiah (a2e792d6).pdf"
+		if paths(entries) != expected {
+			t.Errorf("expected %s, got %s", expected, paths(entries))
+		}
+	})
+
+	t.Run("Flat", func(t *testing.T) {
+		_, entries := list(t, FoldersFlat)
+
+		expected := "Dune (94a8dab1).kepub.epub,A_B (4fbb46e9).epub,Dune Messiah (a2e792d6).pdf"
+		if paths(entries) != expected {
+			t.Errorf("expected %s, got %s", expected, paths(entries))
+		}
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		client, err := NewClient(cacert, srv.URL+"/opds", "alice", "wrong", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := client.List(func(backend.Entry) error { return nil }); err != backend.ErrUnauthorized {
+			t.Errorf("expected %v, got %v", backend.ErrUnauthorized, err)
+		}
+	})
+}
+
+func TestListSameTitle(t *testing.T) {
+	entries := []string{
+		`<entry><title>Poems</title><id>urn:book:1</id><link rel="http://opds-spec.org/acquisition" href="/download/1.epub" type="application/epub+zip"/></entry>`,
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, feedTpl, "Catalog", strings.Join(entries, ""))
+	}))
+	defer srv.Close()
+
+	client, err := NewClient(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), srv.URL+"/opds", "", "", FoldersFlat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := func() map[string]string {
+		books := map[string]string{}
+		err := client.List(func(entry backend.Entry) error {
+			books[entry.Path] = client.books[entry.Path]
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return books
+	}
+
+	// A book keeps its path when another one with the same title is added to the catalog
+	alone := list()
+	entries = append(entries, `<entry><title>Poems</title><id>urn:book:2</id><link rel="http://opds-spec.org/acquisition" href="/download/2.epub" type="application/epub+zip"/></entry>`)
+	books := list()
+	for bookPath, href := range alone {
+		if books[bookPath] != href {
+			t.Errorf("expected %s to keep its path, got %v", href, books)
+		}
+	}
+	if len(books) != 2 {
+		t.Errorf("expected the books to be told apart by their id, got %v", books)
+	}
+
+	// Or when the catalog lists them in another order
+	entries[0], entries[1] = entries[1], entries[0]
+	if reordered := list(); fmt.Sprint(reordered) != fmt.Sprint(books) {
+		t.Errorf("expected %v, got %v", books, reordered)
+	}
+}
+
+func TestAcquisition(t *testing.T) {
+	e := entry{Links: []link{
+		{Rel: relAcquisition, Href: "a.pdf", Type: "application/pdf"},
+		{Rel: relAcquisition, Href: "a.epub", Type: "application/epub+zip; charset=binary"},
+	}}
+
+	l, extension, ok := e.acquisition()
+	if ok == false || l.Href != "a.epub" || extension != ".epub" {
+		t.Errorf("expected the epub link, got %+v %s", l, extension)
+	}
+}
@@ -0,0 +1,245 @@
+// Package s3 syncs a library from a prefix of an S3-compatible bucket, with requests signed with AWS Signature V4.
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"kloud/pkg/backend"
+)
+
+// Errors returned when downloading an object
+var (
+	ErrChanged = errors.New("object changed during the download")
+)
+
+// Codes of the S3 errors meaning the credentials are wrong, the others are reported with their code
+var unauthorizedCodes = map[string]bool{
+	"InvalidAccessKeyId": true,
+	"AccessDenied":       true,
+}
+
+// Hints added to the S3 errors whose message does not tell what to fix
+var errorHints = map[string]string{
+	"RequestTimeTooSkewed":  "clock skew, the clock of the device is too far from the one of the server",
+	"SignatureDoesNotMatch": "check the secret key",
+}
+
+// Error is an error response of the S3 API. It unwraps to the backend.StatusError of its HTTP status.
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e Error) Error() string {
+	msg := fmt.Sprintf("S3 error %s (HTTP %d): %s", e.Code, e.StatusCode, e.Message)
+	if hint, ok := errorHints[e.Code]; ok {
+		msg += " (" + hint + ")"
+	}
+	return msg
+}
+
+func (e Error) Unwrap() error {
+	return backend.StatusError{StatusCode: e.StatusCode}
+}
+
+// listBucketResult is the response of a ListObjectsV2 request
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int64  `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+// Client lists and downloads the objects of a bucket of an S3-compatible object storage, such as MinIO
+type Client struct {
+	http        http.Client
+	endpoint    *url.URL
+	bucket      string
+	prefix      string
+	region      string
+	accessKey   string
+	secretKey   string
+	virtualHost bool
+
+	// now returns the time used to sign requests
+	now func() time.Time
+}
+
+// Client resumes interrupted downloads with range requests, failing when the object changed meanwhile
+var (
+	_ backend.Backend     = (*Client)(nil)
+	_ backend.RangeOpener = (*Client)(nil)
+)
+
+// NewClient creates a new S3 client for the objects of bucket under prefix with the configured TLS settings.
+// Requests are sent to endpoint/bucket, or to bucket.endpoint when virtualHost is true, and are only signed
+// when accessKey is set so public buckets can be synced anonymously.
+func NewClient(cacert []byte, endpoint, region, bucket, prefix, accessKey, secretKey string, virtualHost bool) (*Client, error) {
+	httpClient, err := backend.NewHTTPClient(cacert)
+	if err != nil {
+		return nil, err
+	}
+
+	endpointURL, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	// Only list the objects inside the prefix folder, not the ones sharing its beginning
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix != "" && strings.HasSuffix(prefix, "/") == false {
+		prefix += "/"
+	}
+
+	return &Client{
+		http:        httpClient,
+		endpoint:    endpointURL,
+		bucket:      bucket,
+		prefix:      prefix,
+		region:      region,
+		accessKey:   accessKey,
+		secretKey:   secretKey,
+		virtualHost: virtualHost,
+		now:         time.Now,
+	}, nil
+}
+
+// List calls fn for every object under the prefix, following the pagination of ListObjectsV2.
+// Keys ending with a slash are folder placeholders created by some clients and are skipped.
+func (c *Client) List(fn func(backend.Entry) error) error {
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {c.prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := c.do("GET", "", query, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, object := range result.Contents {
+			objectPath := strings.TrimPrefix(object.Key, c.prefix)
+			if objectPath == "" || strings.HasSuffix(objectPath, "/") {
+				continue
+			}
+
+			entry := backend.Entry{Path: objectPath, Size: object.Size, ETag: strings.Trim(object.ETag, `"`)}
+			if lastModified, err := time.Parse(time.RFC3339, object.LastModified); err == nil {
+				entry.ModTime = lastModified
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+
+		if result.IsTruncated == false || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Open downloads an object and returns a stream of its contents
+func (c *Client) Open(objectPath string) (io.ReadCloser, error) {
+	resp, err := c.do("GET", c.prefix+objectPath, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// OpenRange downloads an object from offset and returns a stream of the rest of its contents
+func (c *Client) OpenRange(objectPath, etag string, offset int64) (io.ReadCloser, error) {
+	headers := map[string]string{"Range": fmt.Sprintf("bytes=%d-", offset)}
+	if etag != "" {
+		headers["If-Match"] = `"` + etag + `"`
+	}
+
+	resp, err := c.do("GET", c.prefix+objectPath, nil, headers, http.StatusPartialContent)
+	if err != nil {
+		var statusErr backend.StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusPreconditionFailed {
+			return nil, ErrChanged
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// objectURL returns the URL of an object of the bucket, or of the bucket itself when key is empty
+func (c *Client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	objectPath := u.Path + "/" + c.bucket + "/" + key
+	if c.virtualHost {
+		u.Host = c.bucket + "." + u.Host
+		objectPath = u.Path + "/" + key
+	}
+
+	// Send the path encoded exactly as it is signed
+	u.Path = objectPath
+	u.RawPath = uriEncode(objectPath, true)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// do performs a signed request and checks its status
+func (c *Client) do(method, key string, query url.Values, headers map[string]string, expected int) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.objectURL(key, query).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if c.accessKey != "" {
+		sign(req, c.accessKey, c.secretKey, c.region, c.now())
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == expected {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	// Errors come with an XML body telling their code, forbidden requests without one are refused credentials
+	s3Err := Error{StatusCode: resp.StatusCode}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&s3Err); err != nil || s3Err.Code == "" {
+		if resp.StatusCode == http.StatusForbidden {
+			return nil, backend.ErrUnauthorized
+		}
+		return nil, backend.StatusError{StatusCode: resp.StatusCode}
+	}
+	if unauthorizedCodes[s3Err.Code] {
+		return nil, fmt.Errorf("%w: %s", backend.ErrUnauthorized, s3Err)
+	}
+	return nil, s3Err
+}
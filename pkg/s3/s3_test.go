@@ -0,0 +1,208 @@
+package s3
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kloud/pkg/backend"
+)
+
+const testContent = "The spice must flow."
+
+func newTestServer(requests *[]*http.Request) (*httptest.Server, []byte) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r)
+
+		switch {
+		case strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=denied/"):
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+			return
+		case strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=skewed/"):
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Error><Code>RequestTimeTooSkewed</Code><Message>The difference between the request time and the current time is too large.</Message></Error>`)
+			return
+		}
+
+		if strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") == false {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.EscapedPath() {
+		case "/library/":
+			if r.URL.Query().Get("continuation-token") == "" {
+				fmt.Fprint(w, `<ListBucketResult>
+					<IsTruncated>true</IsTruncated>
+					<NextContinuationToken>page 2</NextContinuationToken>
+					<Contents><Key>books/</Key><Size>0</Size></Contents>
+					<Contents><Key>books/Dune.epub</Key><LastModified>2021-11-28T18:30:15.000Z</LastModified><ETag>"d41d8cd98f00b204e9800998ecf8427e"</ETag><Size>20</Size></Contents>
+				</ListBucketResult>`)
+				return
+			}
+			fmt.Fprint(w, `<ListBucketResult>
+				<IsTruncated>false</IsTruncated>
+				<Contents><Key>books/Herbert/Children of Dune.epub</Key><ETag>"9b2cf535f27731c974343645a3985328-2"</ETag><Size>1024</Size></Contents>
+			</ListBucketResult>`)
+		case "/library/books/Herbert/Children%20of%20Dune.epub":
+			if r.Header.Get("If-Match") != `"9b2cf535f27731c974343645a3985328-2"` {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+
+			var offset int
+			fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset)
+			w.WriteHeader(http.StatusPartialContent)
+			fmt.Fprint(w, testContent[offset:])
+		default:
+			fmt.Fprint(w, testContent)
+		}
+	})
+
+	srv := httptest.NewTLSServer(handler)
+	return srv, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+}
+
+func TestList(t *testing.T) {
+	var requests []*http.Request
+	srv, cacert := newTestServer(&requests)
+	defer srv.Close()
+
+	client, err := NewClient(cacert, srv.URL, "", "library", "books", "access", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []backend.Entry
+	err = client.List(func(entry backend.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []backend.Entry{
+		{Path: "Dune.epub", Size: 20, ModTime: time.Date(2021, time.November, 28, 18, 30, 15, 0, time.UTC), ETag: "d41d8cd98f00b204e9800998ecf8427e"},
+		{Path: "Herbert/Children of Dune.epub", Size: 1024, ETag: "9b2cf535f27731c974343645a3985328-2"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %v", len(expected), entries)
+	}
+	for i := range expected {
+		if entries[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], entries[i])
+		}
+	}
+
+	// Both pages are listed under the prefix folder
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+	for _, req := range requests {
+		if prefix := req.URL.Query().Get("prefix"); prefix != "books/" {
+			t.Errorf("unexpected prefix %s", prefix)
+		}
+	}
+	if token := requests[1].URL.Query().Get("continuation-token"); token != "page 2" {
+		t.Errorf("unexpected continuation token %s", token)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	var requests []*http.Request
+	srv, cacert := newTestServer(&requests)
+	defer srv.Close()
+
+	client, err := NewClient(cacert, srv.URL, "", "library", "books/", "access", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := client.Open("Dune.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(body)
+	body.Close()
+	if string(content) != testContent {
+		t.Errorf("unexpected content %q", content)
+	}
+	if path := requests[0].URL.Path; path != "/library/books/Dune.epub" {
+		t.Errorf("unexpected path %s", path)
+	}
+
+	body, err = client.OpenRange("Herbert/Children of Dune.epub", "9b2cf535f27731c974343645a3985328-2", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ = ioutil.ReadAll(body)
+	body.Close()
+	if string(content) != testContent[4:] {
+		t.Errorf("unexpected content %q", content)
+	}
+
+	_, err = client.OpenRange("Herbert/Children of Dune.epub", "outdated", 4)
+	if errors.Is(err, ErrChanged) == false {
+		t.Errorf("expected ErrChanged, got %v", err)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	var requests []*http.Request
+	srv, cacert := newTestServer(&requests)
+	defer srv.Close()
+
+	client, err := NewClient(cacert, srv.URL, "", "library", "", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.List(func(backend.Entry) error { return nil })
+	if errors.Is(err, backend.ErrUnauthorized) == false {
+		t.Errorf("expected backend.ErrUnauthorized, got %v", err)
+	}
+	if auth := requests[0].Header.Get("Authorization"); auth != "" {
+		t.Errorf("anonymous requests should not be signed, got %s", auth)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	var requests []*http.Request
+	srv, cacert := newTestServer(&requests)
+	defer srv.Close()
+
+	client, err := NewClient(cacert, srv.URL, "", "library", "", "denied", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.List(func(backend.Entry) error { return nil })
+	if errors.Is(err, backend.ErrUnauthorized) == false {
+		t.Errorf("expected backend.ErrUnauthorized, got %v", err)
+	}
+
+	// A skewed clock is not a matter of credentials
+	client, err = NewClient(cacert, srv.URL, "", "library", "", "skewed", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.List(func(backend.Entry) error { return nil })
+	var s3Err Error
+	if errors.As(err, &s3Err) == false || s3Err.Code != "RequestTimeTooSkewed" || errors.Is(err, backend.ErrUnauthorized) {
+		t.Fatalf("expected a RequestTimeTooSkewed error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "clock skew") == false {
+		t.Errorf("expected the error to mention the clock skew, got %v", err)
+	}
+	var statusErr backend.StatusError
+	if errors.As(err, &statusErr) == false || statusErr.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the HTTP status of the error, got %v", err)
+	}
+}
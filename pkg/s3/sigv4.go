@@ -0,0 +1,119 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// signingAlgorithm is the AWS Signature Version 4 algorithm
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	// emptyPayloadHash is the SHA256 of an empty body, as sent by every request of the client
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	amzDateFormat   = "20060102T150405Z"
+	shortDateFormat = "20060102"
+)
+
+// sign adds the AWS Signature Version 4 headers to a request without body
+func sign(req *http.Request, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(amzDateFormat)
+	scope := strings.Join([]string{now.Format(shortDateFormat), region, "s3", "aws4_request"}, "/")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	// Sign the host, the range and the x-amz headers
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		key = strings.ToLower(key)
+		if key == "range" || strings.HasPrefix(key, "x-amz-") {
+			headers[key] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{signingAlgorithm, amzDate, scope, hexSHA256(canonicalRequest)}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), now.Format(shortDateFormat))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgorithm, accessKey, scope, signedHeaders, signature))
+}
+
+// uriEncode encodes a string the way AWS expects, leaving only unreserved characters and optionally slashes
+func uriEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', keepSlash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalURI encodes the decoded path of a request
+func canonicalURI(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return uriEncode(path, true)
+}
+
+// canonicalQuery encodes and sorts the query parameters of a request
+func canonicalQuery(query map[string][]string) string {
+	var params []string
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(key, false)+"="+uriEncode(value, false))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,149 @@
+// Package sftp syncs a library from a folder of an SSH server, authenticated with a key and pinned host keys.
+package sftp
+
+import (
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"kloud/pkg/backend"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Client lists and downloads the files of a folder of an SSH server, such as a NAS
+type Client struct {
+	address string
+	config  *ssh.ClientConfig
+	root    string
+
+	ssh  *ssh.Client
+	sftp *sftp.Client
+}
+
+// Client only lists and downloads, the server is never written to
+var _ backend.Backend = (*Client)(nil)
+
+// NewClient creates a new SFTP client for folder on the server at address, port 22 if it is missing.
+// The client authenticates with the private key and only trusts the host keys listed in knownHostsPath.
+// The connection is only opened by the first call to List or Open.
+func NewClient(address, user string, key []byte, knownHostsPath, folder string) (*Client, error) {
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+
+	if folder == "" {
+		folder = "."
+	}
+
+	return &Client{
+		address: address,
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+		},
+		root: path.Clean(folder),
+	}, nil
+}
+
+// connect opens the SSH connection and the SFTP session if they are not open yet
+func (c *Client) connect() error {
+	if c.sftp != nil {
+		return nil
+	}
+
+	conn, err := ssh.Dial("tcp", c.address, c.config)
+	if err != nil {
+		return err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	c.ssh, c.sftp = conn, client
+	return nil
+}
+
+// Close closes the SFTP session and the SSH connection
+func (c *Client) Close() error {
+	if c.sftp == nil {
+		return nil
+	}
+
+	c.sftp.Close()
+	err := c.ssh.Close()
+	c.ssh, c.sftp = nil, nil
+	return err
+}
+
+// List walks the folder and calls fn for every regular file, following symbolic links to files
+func (c *Client) List(fn func(backend.Entry) error) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+
+	prefix := c.root + "/"
+	if c.root == "." {
+		prefix = ""
+	} else if c.root == "/" {
+		prefix = "/"
+	}
+
+	walker := c.sftp.Walk(c.root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+
+		info := walker.Stat()
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := c.sftp.Stat(walker.Path())
+			if err != nil {
+				// Dangling links are not worth failing the sync
+				continue
+			}
+			info = target
+		}
+		if info.Mode().IsRegular() == false {
+			continue
+		}
+
+		entry := backend.Entry{
+			Path:    strings.TrimPrefix(walker.Path(), prefix),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Open returns a stream of the contents of a file of the folder
+func (c *Client) Open(filePath string) (io.ReadCloser, error) {
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+
+	return c.sftp.Open(path.Join(c.root, filePath))
+}
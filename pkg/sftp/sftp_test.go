@@ -0,0 +1,186 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"kloud/pkg/backend"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newTestServer starts an SSH server with an SFTP subsystem serving the local filesystem.
+// It returns its address, a private key it accepts, and the path of a known_hosts file trusting it.
+func newTestServer(t *testing.T) (string, []byte, string) {
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientPublicKey, clientKey, _ := ed25519.GenerateKey(rand.Reader)
+	authorizedKey, err := ssh.NewPublicKey(clientPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), authorizedKey.Marshal()) == false {
+				return nil, os.ErrPermission
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go serve(listener, config)
+
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(listener.Addr().String())}, hostSigner.PublicKey())
+	if err := ioutil.WriteFile(knownHostsPath, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return listener.Addr().String(), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), knownHostsPath
+}
+
+func serve(listener net.Listener, config *ssh.ServerConfig) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			_, channels, requests, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(requests)
+
+			for newChannel := range channels {
+				channel, requests, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+
+				go func() {
+					for req := range requests {
+						req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+						if req.Type == "subsystem" {
+							server, _ := sftp.NewServer(channel)
+							server.Serve()
+							channel.Close()
+						}
+					}
+				}()
+			}
+		}()
+	}
+}
+
+func newTestLibrary(t *testing.T) string {
+	root := t.TempDir()
+	modTime := time.Date(2021, time.November, 28, 18, 30, 15, 0, time.UTC)
+
+	for name, content := range map[string]string{
+		"Dune.epub":                    "The spice must flow.",
+		"Herbert/Children of Dune.pdf": "Children",
+	} {
+		fullPath := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(fullPath), 0700)
+		if err := ioutil.WriteFile(fullPath, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(fullPath, modTime, modTime)
+	}
+
+	os.Mkdir(filepath.Join(root, "Empty"), 0700)
+	os.Symlink(filepath.Join(root, "Dune.epub"), filepath.Join(root, "Link.epub"))
+	os.Symlink(filepath.Join(root, "missing"), filepath.Join(root, "Dangling.epub"))
+	return root
+}
+
+func TestList(t *testing.T) {
+	address, key, knownHostsPath := newTestServer(t)
+	root := newTestLibrary(t)
+
+	client, err := NewClient(address, "kobo", key, knownHostsPath, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var entries []backend.Entry
+	err = client.List(func(entry backend.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	modTime := time.Date(2021, time.November, 28, 18, 30, 15, 0, time.UTC)
+	expected := []backend.Entry{
+		{Path: "Dune.epub", Size: 20, ModTime: modTime},
+		{Path: "Herbert/Children of Dune.pdf", Size: 8, ModTime: modTime},
+		{Path: "Link.epub", Size: 20, ModTime: modTime},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %v", len(expected), entries)
+	}
+	for i := range expected {
+		if entries[i].Path != expected[i].Path || entries[i].Size != expected[i].Size || entries[i].ModTime.Equal(expected[i].ModTime) == false {
+			t.Errorf("expected %v, got %v", expected[i], entries[i])
+		}
+	}
+
+	body, err := client.Open("Herbert/Children of Dune.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(body)
+	body.Close()
+	if string(content) != "Children" {
+		t.Errorf("unexpected content %q", content)
+	}
+}
+
+func TestUnknownHostKey(t *testing.T) {
+	address, key, _ := newTestServer(t)
+	_, _, otherKnownHostsPath := newTestServer(t)
+
+	// The known_hosts file trusts another server listening on another port
+	client, err := NewClient(address, "kobo", key, otherKnownHostsPath, newTestLibrary(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.List(func(backend.Entry) error { return nil })
+	if err == nil {
+		t.Error("expected the connection to be refused")
+	}
+}
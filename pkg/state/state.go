@@ -0,0 +1,72 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"kloud/pkg/backend"
+)
+
+// State is what kloud remembers from one sync to the next
+type State struct {
+	// ETags maps the synced files to the version the backend reported when they were downloaded
+	ETags map[string]string `json:"etags"`
+	// Version is the version of the whole library at the last successful sync, for backends reporting one
+	Version string `json:"version,omitempty"`
+	// SyncToken identifies the remote listing for backends able to list the changes since then
+	SyncToken string `json:"sync_token,omitempty"`
+	// Remote is the remote listing identified by SyncToken
+	Remote map[string]backend.Entry `json:"remote,omitempty"`
+	// Redownload lists the files to download again on the next sync, because they were found corrupted
+	Redownload []string `json:"redownload,omitempty"`
+	// NickelCleanup lists the deleted files left to remove from the library of Nickel, which held its database
+	NickelCleanup []string `json:"nickel_cleanup,omitempty"`
+	// Collections lists the collections of Nickel kloud maintains
+	Collections []string `json:"collections,omitempty"`
+	// CollectionsPending is set when books are left to add to their collections, once Nickel imports them
+	CollectionsPending bool `json:"collections_pending,omitempty"`
+	// Annotations maps the exported annotation files to the SHA-256 of their content, to only upload changes
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Progress is the SHA-256 of the progress document of the device last published
+	Progress string `json:"progress,omitempty"`
+	// HeldBack maps the files kept because they are being read to when their deletion was first held back
+	HeldBack map[string]time.Time `json:"held_back,omitempty"`
+	// LastSuccess is the end of the last successful sync
+	LastSuccess time.Time `json:"last_success"`
+}
+
+// Load reads the state saved at path, or returns an empty state if none was saved yet
+func Load(path string) (State, error) {
+	state := State{ETags: map[string]string{}}
+
+	in, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+
+	if err := json.Unmarshal(in, &state); err != nil {
+		return State{}, err
+	}
+	if state.ETags == nil {
+		state.ETags = map[string]string{}
+	}
+	return state, nil
+}
+
+// Save writes the state to path. The previous state is only replaced once the new one is fully written.
+func (state State) Save(path string) error {
+	out, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path+".tmp", out, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
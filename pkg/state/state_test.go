@@ -0,0 +1,32 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	// Nothing was saved before the first sync
+	state, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.ETags) != 0 {
+		t.Errorf("expected an empty state, got %v", state)
+	}
+
+	state.ETags["Herbert/Dune.epub"] = "d41d8cd98f00b204e9800998ecf8427e"
+	if err := state.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag := state.ETags["Herbert/Dune.epub"]; etag != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Errorf("unexpected etag %q", etag)
+	}
+}
@@ -0,0 +1,117 @@
+// Package webdav syncs a library from a collection of a WebDAV server, and writes to it for the exports of kloud.
+package webdav
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"kloud/pkg/backend"
+)
+
+// Errors returned by the NewClient function
+var (
+	ErrUnableToAppendCerts = backend.ErrUnableToAppendCerts
+	ErrUnknownTraversal    = errors.New("unknown traversal mode")
+)
+
+// Traversal is the strategy used to list the remote collection
+type Traversal string
+
+// Traversal modes supported by the client
+const (
+	// TraversalAuto tries a Depth: infinity PROPFIND and falls back to TraversalWalk if the server refuses it
+	TraversalAuto Traversal = "auto"
+	// TraversalInfinity lists the whole collection with a single Depth: infinity PROPFIND
+	TraversalInfinity Traversal = "infinity"
+	// TraversalWalk lists the collection breadth-first with one Depth: 1 PROPFIND per collection
+	TraversalWalk Traversal = "walk"
+)
+
+// StatusError is returned when the server answers with an unexpected HTTP status code
+type StatusError = backend.StatusError
+
+// Auth authenticates a request to the server
+type Auth func(req *http.Request)
+
+// BasicAuth authenticates requests with a user name and a password
+func BasicAuth(user, password string) Auth {
+	return func(req *http.Request) {
+		req.SetBasicAuth(user, password)
+	}
+}
+
+// BearerAuth authenticates requests with a bearer token
+func BearerAuth(token string) Auth {
+	return func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// Client is a WebDAV client for Kloud and wraps http.Client.
+// It reads the collection at its base URL, and every path it handles is relative to that collection.
+type Client struct {
+	http      http.Client
+	base      string
+	root      string
+	auth      Auth
+	traversal Traversal
+}
+
+// NewClient creates a new WebDAV client for the collection at baseURL with the configured TLS settings.
+// auth may be nil for servers that do not require authentication.
+func NewClient(cacert []byte, baseURL string, auth Auth, traversal Traversal) (Client, error) {
+	httpClient, err := backend.NewHTTPClient(cacert)
+	if err != nil {
+		return Client{}, err
+	}
+
+	switch traversal {
+	case "":
+		traversal = TraversalAuto
+	case TraversalAuto, TraversalInfinity, TraversalWalk:
+	default:
+		return Client{}, ErrUnknownTraversal
+	}
+
+	// Hrefs returned by the server are compared to the decoded path of the collection
+	parsedURL, err := url.Parse(baseURL)
+	if err != nil {
+		return Client{}, err
+	}
+	root := strings.TrimSuffix(parsedURL.Path, "/")
+	base := strings.TrimSuffix(parsedURL.String(), "/")
+
+	return Client{
+		http:      httpClient,
+		base:      base,
+		root:      root,
+		auth:      auth,
+		traversal: traversal,
+	}, nil
+}
+
+// davURL returns the URL of a path relative to the collection, escaping it as needed
+func (c *Client) davURL(path string) string {
+	return c.base + "/" + (&url.URL{Path: path}).EscapedPath()
+}
+
+// do authenticates and performs a request, turning authentication failures into backend.ErrUnauthorized
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.auth != nil {
+		c.auth(req)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, backend.ErrUnauthorized
+	}
+
+	return resp, nil
+}
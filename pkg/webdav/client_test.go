@@ -0,0 +1,102 @@
+package webdav
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kloud/pkg/backend"
+)
+
+func TestGenericServer(t *testing.T) {
+	// Answer like a minimal server: unencoded hrefs, no getcontentlength on some files and no namespace prefix
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == "GET" {
+			fmt.Fprint(w, "content")
+			return
+		}
+
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?>
+<multistatus xmlns="DAV:">
+<response><href>/dav/My Books/</href><propstat><prop><resourcetype><collection/></resourcetype></prop><status>HTTP/1.1 200 OK</status></propstat></response>
+<response><href>/dav/My Books/Ébook #1.epub</href><propstat><prop><resourcetype/><getcontentlength>7</getcontentlength><getlastmodified>Sun, 28 Nov 2021 18:30:15 GMT</getlastmodified></prop><status>HTTP/1.1 200 OK</status></propstat></response>
+<response><href>/dav/My%20Books/generated.epub</href><propstat><prop><resourcetype/></prop><status>HTTP/1.1 200 OK</status></propstat></response>
+</multistatus>`)
+	}))
+	defer srv.Close()
+	cacert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	t.Run("Listing", func(t *testing.T) {
+		client, err := NewClient(cacert, srv.URL+"/dav/My Books/", BearerAuth("t0k3n"), TraversalInfinity)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files, err := listFiles(&client)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(files) != 2 {
+			t.Errorf("expected 2 files, got %v", files)
+		}
+		if file := files["Ébook #1.epub"]; file.Size != 7 || file.ModTime.IsZero() {
+			t.Errorf("unexpected file %+v", file)
+		}
+		if file, ok := files["generated.epub"]; ok == false || file.Size != -1 {
+			t.Errorf("unexpected file %+v", file)
+		}
+
+		content, err := readFile(&client, "Ébook #1.epub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "content" {
+			t.Errorf("expected content, got %s", content)
+		}
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		client, err := NewClient(cacert, srv.URL+"/dav/My%20Books", nil, TraversalInfinity)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := listFiles(&client); err != backend.ErrUnauthorized {
+			t.Errorf("expected %v, got %v", backend.ErrUnauthorized, err)
+		}
+	})
+}
+
+// listFiles lists the remote collection by path
+func listFiles(client *Client) (map[string]File, error) {
+	files := map[string]File{}
+	err := client.ListRemoteFiles(func(file File) error {
+		files[file.Path] = file
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// readFile downloads the content of a file
+func readFile(client *Client, fileName string) ([]byte, error) {
+	body, err := client.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
@@ -0,0 +1,97 @@
+package webdav
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"kloud/pkg/backend"
+)
+
+const propfindPayload = `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+	<d:prop>
+		<d:resourcetype />
+		<d:getcontentlength />
+		<d:getlastmodified />
+		<d:getetag />
+		<oc:checksums />
+	</d:prop>
+</d:propfind>`
+
+// Client lists the collection with PROPFIND requests and downloads with plain GETs
+var _ backend.Backend = (*Client)(nil)
+
+// List lists the files of the remote collection as backend entries
+func (c *Client) List(fn func(backend.Entry) error) error {
+	return c.ListRemoteFiles(func(file File) error {
+		return fn(backend.Entry{Path: file.Path, Size: file.Size, ModTime: file.ModTime, ETag: file.ETag, Checksum: file.Checksum})
+	})
+}
+
+// ListRemoteFiles lists the files in the remote collection and calls fn for each of them as they are decoded.
+// fn is never called concurrently, and the listing stops at the first error it returns.
+func (c *Client) ListRemoteFiles(fn func(File) error) error {
+	// List the collection with the configured strategy, falling back to a walk if Depth: infinity is refused
+	switch c.traversal {
+	case TraversalInfinity:
+		return c.listInfinity(fn)
+	case TraversalWalk:
+		return c.walk(fn)
+	default:
+		err := c.listInfinity(fn)
+		var statusErr StatusError
+		if errors.As(err, &statusErr) {
+			return c.walk(fn)
+		}
+		return err
+	}
+}
+
+// propfind lists a collection with the given depth, calling fn for each response
+func (c *Client) propfind(path, depth string, fn func(Resource) error) error {
+	// Build the request with depth
+	req, err := http.NewRequest("PROPFIND", c.davURL(path), strings.NewReader(propfindPayload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml")
+
+	// Run the request with auth
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return StatusError{StatusCode: resp.StatusCode}
+	}
+
+	// Parse the response as it is received
+	return decodeMultistatus(resp.Body, c.root, fn)
+}
+
+// Open downloads a single file from its path and returns a stream of its contents
+func (c *Client) Open(fileName string) (io.ReadCloser, error) {
+	// Prepare the request
+	req, err := http.NewRequest("GET", c.davURL(fileName), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Perform the request with auth and return the response body
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, StatusError{StatusCode: resp.StatusCode}
+	}
+
+	return resp.Body, nil
+}
@@ -0,0 +1,212 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"kloud/pkg/checksum"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger receives the warnings about the properties of the resources that cannot be read
+var Logger logrus.FieldLogger = logrus.StandardLogger()
+
+// File is a WebDAV remote file. Size is -1 when the server does not report it.
+type File struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	ETag    string
+	// Checksum is the strongest checksum the server reports, empty when it reports none
+	Checksum string
+}
+
+// Resource is a single response of a multistatus document, either a file or a collection
+type Resource struct {
+	File
+	Collection bool
+}
+
+// Errors returned while decoding a multistatus document
+var (
+	ErrHrefOutsideRoot = errors.New("href is outside of the collection")
+)
+
+// prop is the XML representation of the properties of a resource
+type prop struct {
+	Collection   *struct{} `xml:"resourcetype>collection"`
+	Size         string    `xml:"getcontentlength"`
+	LastModified string    `xml:"getlastmodified"`
+	ETag         string    `xml:"getetag"`
+	Checksums    []string  `xml:"checksums>checksum"`
+}
+
+// response is the XML representation of a single response of a multistatus document
+type response struct {
+	Href      string `xml:"href"`
+	Status    string `xml:"status"`
+	Propstats []struct {
+		Prop   prop   `xml:"prop"`
+		Status string `xml:"status"`
+	} `xml:"propstat"`
+}
+
+// statusOK reports whether a DAV status line such as "HTTP/1.1 200 OK" is a success.
+// A missing status is considered a success as some servers omit it.
+func statusOK(status string) bool {
+	fields := strings.Fields(status)
+	if len(fields) < 2 {
+		return true
+	}
+	return strings.HasPrefix(fields[1], "2")
+}
+
+// relativePath converts an href, either absolute or relative to the server, to a decoded path relative to root
+func relativePath(href, root string) (string, error) {
+	// Keep the path of absolute hrefs. The href is not parsed as a URL as some servers do not escape # or ?
+	escapedPath := href
+	if i := strings.Index(escapedPath, "://"); i >= 0 {
+		escapedPath = escapedPath[i+len("://"):]
+		if j := strings.Index(escapedPath, "/"); j >= 0 {
+			escapedPath = escapedPath[j:]
+		} else {
+			escapedPath = "/"
+		}
+	}
+
+	// Decode with the path escaping rules, keeping literal + signs
+	path, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return "", err
+	}
+
+	path = strings.TrimSuffix(path, "/")
+	if path == root {
+		return "", nil
+	}
+	if strings.HasPrefix(path, root+"/") == false {
+		return "", fmt.Errorf("%w: %s", ErrHrefOutsideRoot, href)
+	}
+
+	return strings.TrimPrefix(path, root+"/"), nil
+}
+
+// resource converts the XML response to a resource with a decoded path relative to root.
+// Properties are only read from the propstats with a successful status.
+func (resp response) resource(root string) (Resource, error) {
+	path, err := relativePath(resp.Href, root)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	res := Resource{File: File{Path: path, Size: -1}}
+	for _, propstat := range resp.Propstats {
+		if statusOK(propstat.Status) == false {
+			continue
+		}
+
+		if propstat.Prop.Collection != nil {
+			res.Collection = true
+		}
+
+		// The size is not sent by every server, for instance for files generated on the fly
+		if size := strings.TrimSpace(propstat.Prop.Size); size != "" {
+			res.Size, err = strconv.ParseInt(size, 10, 64)
+			if err != nil {
+				return Resource{}, err
+			}
+		}
+
+		// The modification time is optional, keep the zero value when the server does not send it or sends
+		// a date in a format HTTP does not allow
+		if propstat.Prop.LastModified != "" {
+			modTime, err := http.ParseTime(propstat.Prop.LastModified)
+			if err != nil {
+				Logger.WithFields(logrus.Fields{"file": path, "modified": propstat.Prop.LastModified}).Warn("Cannot parse the modification time of file")
+			}
+			res.ModTime = modTime
+		}
+
+		if propstat.Prop.ETag != "" {
+			res.ETag = propstat.Prop.ETag
+		}
+
+		// NextCloud lists the checksums computed on upload, when the client sent them
+		if best := checksum.Best(strings.Join(propstat.Prop.Checksums, " ")); best != "" {
+			res.Checksum = best
+		}
+	}
+
+	return res, nil
+}
+
+// DecodeResponses decodes the multistatus element opened by start one response at a time and calls fn for
+// each of them, so that only a single response is held in memory whatever the size of the document.
+// Paths are made relative to root, the decoded path of the collection on the server.
+func DecodeResponses(d *xml.Decoder, start xml.StartElement, root string, fn func(Resource) error) error {
+	if start.Name.Local != "multistatus" {
+		return fmt.Errorf("expected element <multistatus> but have <%s>", start.Name.Local)
+	}
+
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			// Ignore anything that is not a response, such as sync tokens
+			if element.Name.Local != "response" {
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			var resp response
+			if err := d.DecodeElement(&resp, &element); err != nil {
+				return err
+			}
+
+			// Members the server could not describe carry their own status instead of propstats
+			if statusOK(resp.Status) == false {
+				continue
+			}
+
+			res, err := resp.resource(root)
+			if err != nil {
+				return err
+			}
+			if err := fn(res); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			// Nested elements are consumed entirely above, this closes the multistatus element
+			return nil
+		}
+	}
+}
+
+// decodeMultistatus reads a multistatus document from r and calls fn for each of its responses
+func decodeMultistatus(r io.Reader, root string, fn func(Resource) error) error {
+	d := xml.NewDecoder(r)
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			return DecodeResponses(d, start, root, fn)
+		}
+	}
+}
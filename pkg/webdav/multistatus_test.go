@@ -0,0 +1,265 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeMultistatus(t *testing.T) {
+	rawXML := `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:"><d:response><d:href>/nextcloud/public.php/webdav/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/nextcloud/public.php/webdav/Series/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/nextcloud/public.php/webdav/Series/book%201.epub</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>42</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`
+
+	var resources []Resource
+	err := decodeMultistatus(strings.NewReader(rawXML), "/nextcloud/public.php/webdav", func(res Resource) error {
+		resources = append(resources, res)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Resource{
+		{File: File{Path: "", Size: -1}, Collection: true},
+		{File: File{Path: "Series", Size: -1}, Collection: true},
+		{File: File{Path: "Series/book 1.epub", Size: 42}},
+	}
+	if len(resources) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(resources))
+	}
+	for i := range expected {
+		if resources[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], resources[i])
+		}
+	}
+}
+
+func TestDecodeChecksums(t *testing.T) {
+	rawXML := `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns"><d:response><d:href>/dav/book.epub</d:href><d:propstat><d:prop><d:resourcetype/><d:getetag>"a1"</d:getetag><oc:checksums><oc:checksum>SHA1:A94A8FE5CCB19BA61C4C0873D391E987982FBBD3 MD5:098f6bcd4621d373cade4e832627b4f6 ADLER32:045d01c1</oc:checksum></oc:checksums></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/dav/other.epub</d:href><d:propstat><d:prop><d:resourcetype/></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat><d:propstat><d:prop><oc:checksums/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response></d:multistatus>`
+
+	var files []File
+	err := decodeMultistatus(strings.NewReader(rawXML), "/dav", func(res Resource) error {
+		files = append(files, res.File)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []File{
+		{Path: "book.epub", Size: -1, ETag: `"a1"`, Checksum: "SHA1:a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"},
+		{Path: "other.epub", Size: -1},
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("expected %d files, got %d", len(expected), len(files))
+	}
+	for i := range expected {
+		if files[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], files[i])
+		}
+	}
+}
+
+func TestDecodeBadModTime(t *testing.T) {
+	rawXML := `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:"><d:response><d:href>/dav/book.epub</d:href><d:propstat><d:prop><d:resourcetype/><d:getlastmodified>2021-05-04T10:00:00Z</d:getlastmodified></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/dav/other.epub</d:href><d:propstat><d:prop><d:resourcetype/><d:getlastmodified>Tue, 04 May 2021 10:00:00 GMT</d:getlastmodified></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`
+
+	var files []File
+	err := decodeMultistatus(strings.NewReader(rawXML), "/dav", func(res Resource) error {
+		files = append(files, res.File)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []File{
+		{Path: "book.epub", Size: -1},
+		{Path: "other.epub", Size: -1, ModTime: time.Date(2021, 5, 4, 10, 0, 0, 0, time.UTC)},
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("expected %d files, got %d", len(expected), len(files))
+	}
+	for i := range expected {
+		if files[i].Path != expected[i].Path || files[i].ModTime.Equal(expected[i].ModTime) == false {
+			t.Errorf("expected %+v, got %+v", expected[i], files[i])
+		}
+	}
+}
+
+// shareFiles decodes the files of a multistatus document describing a NextCloud public share
+type shareFiles []File
+
+func (files *shareFiles) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	return DecodeResponses(d, start, "/public.php/webdav", func(res Resource) error {
+		if res.Path != "" && res.Collection == false {
+			*files = append(*files, res.File)
+		}
+		return nil
+	})
+}
+
+func TestDecodeShare(t *testing.T) {
+	equal := func(expected, actual File) {
+		if expected.Path != actual.Path {
+			t.Errorf("Path: expected %v, got %v\n", expected.Path, actual.Path)
+		}
+
+		if expected.Size != actual.Size {
+			t.Errorf("Size: expected %v, got %v\n", expected.Size, actual.Size)
+		}
+
+		if expected.ModTime.Equal(actual.ModTime) == false {
+			t.Errorf("ModTime: expected %v, got %v\n", expected.ModTime, actual.ModTime)
+		}
+	}
+
+	t.Run("Valid XML", func(t *testing.T) {
+		rawXML := `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:" xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns" xmlns:nc="http://nextcloud.org/ns"><d:response><d:href>/public.php/webdav/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat><d:propstat><d:prop><d:getcontentlength/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%201/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat><d:propstat><d:prop><d:getcontentlength/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%201/Deep%20folder/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat><d:propstat><d:prop><d:getcontentlength/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%201/Deep%20folder/deep1.md</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%201/nested1.1.txt</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%201/nested1.2.txt.md</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%202/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat><d:propstat><d:prop><d:getcontentlength/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%202/nested2.1.md</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Readme.md</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/root.txt</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/root2.md</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`
+
+		var files shareFiles
+		if err := xml.Unmarshal([]byte(rawXML), &files); err != nil {
+			t.Error(err)
+		}
+
+		equal(File{Path: "Nested folder 1/Deep folder/deep1.md", Size: 1}, files[0])
+		equal(File{Path: "Nested folder 1/nested1.1.txt", Size: 1}, files[1])
+		equal(File{Path: "Nested folder 1/nested1.2.txt.md", Size: 1}, files[2])
+		equal(File{Path: "Nested folder 2/nested2.1.md", Size: 1}, files[3])
+		equal(File{Path: "Readme.md", Size: 1}, files[4])
+		equal(File{Path: "root.txt", Size: 1}, files[5])
+		equal(File{Path: "root2.md", Size: 1}, files[6])
+	})
+
+	t.Run("Valid XML - no files", func(t *testing.T) {
+		rawXML := `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:" xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns" xmlns:nc="http://nextcloud.org/ns"><d:response><d:href>/public.php/webdav/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat><d:propstat><d:prop><d:getcontentlength/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response></d:multistatus>`
+
+		var files shareFiles
+		if err := xml.Unmarshal([]byte(rawXML), &files); err != nil {
+			t.Error(err)
+		}
+
+		if len(files) != 0 {
+			t.Errorf("len(files) should be 0")
+		}
+	})
+
+	t.Run("Valid XML with modification times", func(t *testing.T) {
+		rawXML := `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:"><d:response><d:href>/public.php/webdav/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype><d:getlastmodified>Sat, 27 Nov 2021 10:00:00 GMT</d:getlastmodified></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/book.epub</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>42</d:getcontentlength><d:getlastmodified>Sun, 28 Nov 2021 18:30:15 GMT</d:getlastmodified></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`
+
+		var files shareFiles
+		if err := xml.Unmarshal([]byte(rawXML), &files); err != nil {
+			t.Error(err)
+		}
+
+		modTime := time.Date(2021, time.November, 28, 18, 30, 15, 0, time.UTC)
+		equal(File{Path: "book.epub", Size: 42, ModTime: modTime}, files[0])
+	})
+
+	t.Run("Unknown elements are ignored", func(t *testing.T) {
+		rawXML := `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:"><d:response><d:href>/public.php/webdav/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/book.epub</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>42</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:sync-token>http://sabre.io/ns/sync/3</d:sync-token></d:multistatus>`
+
+		var files shareFiles
+		if err := xml.Unmarshal([]byte(rawXML), &files); err != nil {
+			t.Error(err)
+		}
+
+		if len(files) != 1 {
+			t.Fatalf("len(files) should be 1, got %d", len(files))
+		}
+		equal(File{Path: "book.epub", Size: 42}, files[0])
+	})
+
+	t.Run("Robust hrefs", func(t *testing.T) {
+		rawXML := `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:"><d:response><d:href>https://cloud.domain.com/public.php/webdav/Comics/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>https://cloud.domain.com/public.php/webdav/Comics/C%2B%2B%20for%20dummies+v2.epub</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>42</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/gone.epub</d:href><d:status>HTTP/1.1 404 Not Found</d:status></d:response></d:multistatus>`
+
+		var files shareFiles
+		if err := xml.Unmarshal([]byte(rawXML), &files); err != nil {
+			t.Error(err)
+		}
+
+		if len(files) != 1 {
+			t.Fatalf("len(files) should be 1, got %d", len(files))
+		}
+		equal(File{Path: "Comics/C++ for dummies+v2.epub", Size: 42}, files[0])
+	})
+
+	t.Run("Failed propstats are ignored", func(t *testing.T) {
+		rawXML := `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:"><d:response><d:href>/public.php/webdav/book.epub</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>42</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat><d:propstat><d:prop><d:getlastmodified>not a date</d:getlastmodified><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response></d:multistatus>`
+
+		var files shareFiles
+		if err := xml.Unmarshal([]byte(rawXML), &files); err != nil {
+			t.Error(err)
+		}
+
+		if len(files) != 1 {
+			t.Fatalf("len(files) should be 1, got %d", len(files))
+		}
+		equal(File{Path: "book.epub", Size: 42}, files[0])
+	})
+
+	t.Run("Empty multistatus", func(t *testing.T) {
+		rawXML := `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:"></d:multistatus>`
+
+		var files shareFiles
+		if err := xml.Unmarshal([]byte(rawXML), &files); err != nil {
+			t.Error(err)
+		}
+
+		if len(files) != 0 {
+			t.Errorf("len(files) should be 0")
+		}
+	})
+
+	t.Run("Href outside of the DAV root", func(t *testing.T) {
+		rawXML := `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:"><d:response><d:href>/remote.php/webdav/book.epub</d:href><d:propstat><d:prop><d:resourcetype/></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`
+
+		var files shareFiles
+		if err := xml.Unmarshal([]byte(rawXML), &files); errors.Is(err, ErrHrefOutsideRoot) == false {
+			t.Errorf("expected %v but got %v", ErrHrefOutsideRoot, err)
+		}
+	})
+
+	t.Run("Invalid modification time", func(t *testing.T) {
+		rawXML := `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:"><d:response><d:href>/public.php/webdav/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/book.epub</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>42</d:getcontentlength><d:getlastmodified>yesterday</d:getlastmodified></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`
+
+		var files shareFiles
+		if err := xml.Unmarshal([]byte(rawXML), &files); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(files) != 1 || files[0].Size != 42 || files[0].ModTime.IsZero() == false {
+			t.Errorf("expected the file without its modification time, got %+v", files)
+		}
+	})
+
+	t.Run("Invalid XML", func(t *testing.T) {
+		rawXML := `<?xml version="1.0"?>this is not valid xml<`
+
+		var files shareFiles
+		if err := xml.Unmarshal([]byte(rawXML), &files); err == nil {
+			t.Errorf("Expected an error but got nil")
+		}
+	})
+
+	t.Run("Failing query unescape", func(t *testing.T) {
+		rawXML := `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:" xmlns:s="http://sabredav.org/ns" xmlns:oc="http://owncloud.org/ns" xmlns:nc="http://nextcloud.org/ns"><d:response><d:href>/public.php/webdav/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat><d:propstat><d:prop><d:getcontentlength/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%201/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat><d:propstat><d:prop><d:getcontentlength/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%201/Deep%20folder/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat><d:propstat><d:prop><d:getcontentlength/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%201/Deep%20folder/deep1.md</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%201/nested1.1.txt</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%201/nested1.2.txt.md</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%202/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat><d:propstat><d:prop><d:getcontentlength/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Nested%20folder%202/nested2.1.md</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/Readme.md</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>/public.php/webdav/root.txt</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response><d:response><d:href>%</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>1</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`
+
+		var files shareFiles
+		if err := xml.Unmarshal([]byte(rawXML), &files); err == nil {
+			t.Errorf("Expected an error but got nil")
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package webdav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"kloud/pkg/backend"
+)
+
+const syncCollectionPayload = `<?xml version="1.0"?>
+<d:sync-collection xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+	<d:sync-token>%s</d:sync-token>
+	<d:sync-level>infinite</d:sync-level>
+	<d:prop>
+		<d:resourcetype />
+		<d:getcontentlength />
+		<d:getlastmodified />
+		<d:getetag />
+		<oc:checksums />
+	</d:prop>
+</d:sync-collection>`
+
+// maxErrorSize is the part of an error response read to find the precondition the server reports
+const maxErrorSize = 64 << 10
+
+// Client can list the changes of the collection on servers supporting RFC 6578
+var _ backend.ChangeLister = (*Client)(nil)
+
+// ListChanges lists the changes of the collection since the sync token with a sync-collection REPORT.
+// It fails with backend.ErrNotSupported when the server does not support the report or infinite sync levels.
+func (c *Client) ListChanges(token string, fn func(backend.Change) error) (string, error) {
+	var payload bytes.Buffer
+	if err := xml.EscapeText(&payload, []byte(token)); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("REPORT", c.davURL(""), bytes.NewBufferString(fmt.Sprintf(syncCollectionPayload, payload.String())))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Depth", "0")
+	req.Header.Set("Content-Type", "application/xml")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		// The server tells an expired token apart with the valid-sync-token precondition
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorSize))
+		if token != "" && bytes.Contains(body, []byte("valid-sync-token")) {
+			return "", backend.ErrInvalidToken
+		}
+		return "", fmt.Errorf("%w: %v", backend.ErrNotSupported, StatusError{StatusCode: resp.StatusCode})
+	}
+
+	return decodeSyncCollection(resp.Body, c.root, fn)
+}
+
+// RootETag returns the ETag of the collection itself
+func (c *Client) RootETag() (string, error) {
+	var etag string
+	err := c.propfind("", "0", func(res Resource) error {
+		if res.Path == "" {
+			etag = res.ETag
+		}
+		return nil
+	})
+	return etag, err
+}
+
+// decodeSyncCollection reads the multistatus document of a sync-collection REPORT, calls fn for each changed or
+// deleted file and returns the new sync token
+func decodeSyncCollection(r io.Reader, root string, fn func(backend.Change) error) (string, error) {
+	var token string
+	d := xml.NewDecoder(r)
+
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return token, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		start, ok := t.(xml.StartElement)
+		if ok == false {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "sync-token":
+			if err := d.DecodeElement(&token, &start); err != nil {
+				return "", err
+			}
+		case "response":
+			var resp response
+			if err := d.DecodeElement(&resp, &start); err != nil {
+				return "", err
+			}
+
+			// Removed members only carry a 404 status
+			if statusOK(resp.Status) == false {
+				path, err := relativePath(resp.Href, root)
+				if err != nil {
+					return "", err
+				}
+				if path != "" && strings.Contains(resp.Status, " 404") {
+					if err := fn(backend.Change{Entry: backend.Entry{Path: path}, Deleted: true}); err != nil {
+						return "", err
+					}
+				}
+				continue
+			}
+
+			res, err := resp.resource(root)
+			if err != nil {
+				return "", err
+			}
+			if res.Path == "" || res.Collection {
+				continue
+			}
+
+			entry := backend.Entry{Path: res.Path, Size: res.Size, ModTime: res.ModTime, ETag: res.ETag, Checksum: res.Checksum}
+			if err := fn(backend.Change{Entry: entry}); err != nil {
+				return "", err
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package webdav
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kloud/pkg/backend"
+)
+
+func newSyncTestServer() (*httptest.Server, []byte) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		switch {
+		case r.Method == "PROPFIND" && r.Header.Get("Depth") == "0":
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:">
+<d:response><d:href>/dav/books/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype><d:getetag>"61a3cb17"</d:getetag></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>
+</d:multistatus>`)
+		case r.Method != "REPORT" || r.Header.Get("Depth") != "0":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case strings.Contains(string(body), "<d:sync-token></d:sync-token>"):
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:">
+<d:response><d:href>/dav/books/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>
+<d:response><d:href>/dav/books/Herbert/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>
+<d:response><d:href>/dav/books/Herbert/Dune.epub</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>20</d:getcontentlength><d:getetag>"a1"</d:getetag></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>
+<d:sync-token>http://example.com/sync/1</d:sync-token>
+</d:multistatus>`)
+		case strings.Contains(string(body), "<d:sync-token>http://example.com/sync/1</d:sync-token>"):
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:">
+<d:response><d:href>/dav/books/Herbert/Dune.epub</d:href><d:status>HTTP/1.1 404 Not Found</d:status></d:response>
+<d:response><d:href>/dav/books/Herbert/Dune%20Messiah.epub</d:href><d:propstat><d:prop><d:resourcetype/><d:getcontentlength>21</d:getcontentlength><d:getetag>"b1"</d:getetag></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>
+<d:sync-token>http://example.com/sync/2</d:sync-token>
+</d:multistatus>`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<d:error xmlns:d="DAV:"><d:valid-sync-token/></d:error>`)
+		}
+	}))
+
+	return srv, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+}
+
+func TestListChanges(t *testing.T) {
+	srv, cacert := newSyncTestServer()
+	defer srv.Close()
+
+	client, err := NewClient(cacert, srv.URL+"/dav/books", nil, TraversalAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []backend.Change
+	collect := func(change backend.Change) error {
+		changes = append(changes, change)
+		return nil
+	}
+
+	// The initial sync lists every file
+	token, err := client.ListChanges("", collect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "http://example.com/sync/1" {
+		t.Errorf("unexpected token %s", token)
+	}
+	if len(changes) != 1 || changes[0].Path != "Herbert/Dune.epub" || changes[0].Size != 20 || changes[0].ETag != `"a1"` || changes[0].Deleted {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+
+	// The next sync only lists the changes
+	changes = nil
+	token, err = client.ListChanges(token, collect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "http://example.com/sync/2" {
+		t.Errorf("unexpected token %s", token)
+	}
+	expected := []backend.Change{
+		{Entry: backend.Entry{Path: "Herbert/Dune.epub"}, Deleted: true},
+		{Entry: backend.Entry{Path: "Herbert/Dune Messiah.epub", Size: 21, ETag: `"b1"`}},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %+v", len(expected), changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], changes[i])
+		}
+	}
+
+	// Expired tokens are told apart from servers not supporting the report
+	if _, err := client.ListChanges("http://example.com/sync/0", collect); errors.Is(err, backend.ErrInvalidToken) == false {
+		t.Errorf("expected ErrInvalidToken, got %v", err)
+	}
+
+	etag, err := client.RootETag()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag != `"61a3cb17"` {
+		t.Errorf("unexpected etag %s", etag)
+	}
+}
+
+func TestListChangesNotSupported(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotImplemented)
+	}))
+	defer srv.Close()
+	cacert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	client, err := NewClient(cacert, srv.URL+"/dav/books", nil, TraversalAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.ListChanges("", func(backend.Change) error { return nil })
+	if errors.Is(err, backend.ErrNotSupported) == false {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
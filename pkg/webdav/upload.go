@@ -0,0 +1,65 @@
+package webdav
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"kloud/pkg/backend"
+)
+
+// Client can write to the server, which provided the user has write permissions
+var (
+	_ backend.Uploader = (*Client)(nil)
+	_ backend.Mover    = (*Client)(nil)
+	_ backend.Deleter  = (*Client)(nil)
+)
+
+// Upload creates or replaces a file with the content of r, creating its parent collections as needed
+func (c *Client) Upload(fileName string, r io.Reader) error {
+	// Create every parent collection, the server answers 405 when one already exists
+	parts := strings.Split(fileName, "/")
+	for i := 1; i < len(parts); i++ {
+		err := c.request("MKCOL", strings.Join(parts[:i], "/"), nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.request("PUT", fileName, r, nil, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+}
+
+// Move renames a file, replacing the destination if it exists
+func (c *Client) Move(from, to string) error {
+	headers := map[string]string{"Destination": c.davURL(to), "Overwrite": "T"}
+	return c.request("MOVE", from, nil, headers, http.StatusCreated, http.StatusNoContent)
+}
+
+// Delete removes a file
+func (c *Client) Delete(fileName string) error {
+	return c.request("DELETE", fileName, nil, nil, http.StatusNoContent, http.StatusOK)
+}
+
+// request performs a request without response body on a path, and checks its status is one of expected
+func (c *Client) request(method, path string, body io.Reader, headers map[string]string, expected ...int) error {
+	req, err := http.NewRequest(method, c.davURL(path), body)
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	return StatusError{StatusCode: resp.StatusCode}
+}
@@ -0,0 +1,99 @@
+package webdav
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+const (
+	// maxConcurrentPropfinds is the maximum number of PROPFIND requests in flight while walking the collection
+	maxConcurrentPropfinds = 4
+	// maxWalkDepth protects the walk against servers exposing a never-ending hierarchy
+	maxWalkDepth = 64
+)
+
+// Errors returned while listing the collection
+var (
+	ErrTooDeep = errors.New("remote collection is nested too deeply")
+)
+
+// listInfinity lists the whole collection with a single Depth: infinity PROPFIND
+func (c *Client) listInfinity(fn func(File) error) error {
+	return c.propfind("", "infinity", func(res Resource) error {
+		if res.Collection {
+			return nil
+		}
+		return fn(res.File)
+	})
+}
+
+// walk lists the collection breadth-first, issuing one Depth: 1 PROPFIND per collection
+func (c *Client) walk(fn func(File) error) error {
+	var (
+		mu      sync.Mutex
+		visited = map[string]bool{"": true}
+		level   = []string{""}
+	)
+
+	for depth := 0; len(level) > 0; depth++ {
+		if depth > maxWalkDepth {
+			return ErrTooDeep
+		}
+
+		// List every collection of the current level, with a limited number of concurrent requests.
+		// Files are handed to fn as they are decoded and collections are queued for the next level.
+		var next []string
+		errs := make([]error, len(level))
+		sem := make(chan struct{}, maxConcurrentPropfinds)
+		var wg sync.WaitGroup
+
+		for i, dir := range level {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, dir string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				errs[i] = c.propfind(dir, "1", func(res Resource) error {
+					// Skip the collection itself and anything the server returns outside of it
+					if res.Path == dir || isChild(dir, res.Path) == false {
+						return nil
+					}
+
+					mu.Lock()
+					defer mu.Unlock()
+
+					if res.Collection == false {
+						return fn(res.File)
+					}
+
+					if visited[res.Path] == false {
+						visited[res.Path] = true
+						next = append(next, res.Path)
+					}
+					return nil
+				})
+			}(i, dir)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+
+		level = next
+	}
+
+	return nil
+}
+
+// isChild reports whether path is located inside the dir collection
+func isChild(dir, path string) bool {
+	if dir == "" {
+		return path != ""
+	}
+	return strings.HasPrefix(path, dir+"/")
+}